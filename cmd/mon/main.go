@@ -1,109 +1,333 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/bengreenier/docker-mon/internal/app/mon"
+	"github.com/bengreenier/docker-mon/internal/app/mon/config"
 )
 
-var control = flag.String("control", "unix:///var/run/docker.sock", "Docker control socket")
-var prefix = flag.String("prefix", "", "Docker container prefix to limit observation to")
-var interval = flag.Int64("interval", 5000, "Interval to poll at (in ms)")
-var retries = flag.Int64("retries", 10, "Max retry count for failed docker commands")
-var quiet = flag.Bool("quiet", false, "Only log when action is taken")
+// healthcheckTimeout bounds how long 'mon healthcheck' waits for a response
+const healthcheckTimeout = 5 * time.Second
 
-func main() {
-	flag.Parse()
+// logger is shared by everything main starts
+var logger *mon.Logger
 
-	// TODO(bengreenier): flip this so cli overrides env
-	if s, ok := envStr("MON_CONTROL"); ok {
-		*control = s
+func main() {
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
 	}
-	if s, ok := envStr("MON_PREFIX"); ok {
-		*prefix = s
+	// the flags' errors have already been printed, with the usage
+	if errors.Is(err, config.ErrFlags) {
+		os.Exit(2)
 	}
-	if i, ok := envInt64("MON_INTERVAL"); ok {
-		*interval = i
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	if i, ok := envInt64("MON_RETRIES"); ok {
-		*retries = i
+
+	switch strings.Join(cfg.Args, " ") {
+	case "":
+	case "healthcheck":
+		// ask a running mon (e.g. from a docker HEALTHCHECK) if it's healthy, rather than starting one
+		if err := mon.CheckHealth(cfg.HealthAddr, healthcheckTimeout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "config print":
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: '%s', expected 'healthcheck' or 'config print'\n", strings.Join(cfg.Args, " "))
+		os.Exit(2)
 	}
-	if b, ok := envBool("MON_QUIET"); ok {
-		*quiet = b
+
+	logger = mon.NewLogger(os.Stderr, cfg.Level(), cfg.LogFormat == "json")
+
+	if cfg.Quiet {
+		logger.Warn("quiet is deprecated, and has been replaced by log-level")
 	}
+	if len(cfg.Prefix) > 0 {
+		logger.Warn("prefix is deprecated, and has been replaced by include")
+	}
+
+	logger.With(cfg.Fields()).Info("Starting")
 
-	log.Printf("control: '%s', prefix: '%s', interval: '%v', retries: %v, quiet: %v\n", *control, *prefix, *interval, *retries, *quiet)
+	var metrics *mon.Metrics
+	var metricsServer *http.Server
 
-	monitor := mon.Monitor{
-		Quiet: *quiet,
-		Dockerd: &mon.DockerD{
-			ControlAddr: *control,
-			// see https://docs.docker.com/engine/api/#api-version-matrix
-			TargetVersion:  "1.37",
-			CommandRetries: *retries,
-		},
-		ContainerPrefix: *prefix,
+	if len(cfg.MetricsAddr) > 0 {
+		listener, err := mon.Listen(cfg.MetricsAddr)
+		if err != nil {
+			panic(err)
+		}
+
+		metrics = &mon.Metrics{}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+
+		metricsServer = &http.Server{
+			Handler: mux,
+		}
+
+		go func() {
+			if err := metricsServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logger.WithError(err).Error("Metrics server failed")
+			}
+		}()
 	}
 
-	poll := mon.Poller{
-		IntervalMs: *interval,
-		Handler:    &monitor,
+	if cfg.DryRun {
+		logger.Warn("Dry run, restarts and removals will only be logged")
 	}
 
-	// startup errors trigger immediate exit
-	if err := poll.Start(); err != nil {
-		panic(err)
+	// cancelled on shutdown, to interrupt any in-flight work
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var hosts []*mon.Host
+	settings := cfg.MonitorSettings()
+
+	var tlsConfig *tls.Config
+	if opts := cfg.TLSOptions(); opts.Enabled() {
+		// validated with the rest of the config, but the files may have gone since
+		if tlsConfig, err = opts.Config(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
 	}
 
-	WaitForTERM()
+	for _, control := range cfg.Control {
+		name, addr := mon.ParseControl(control)
+		hostLogger := logger.With(mon.Fields{"host": name})
 
-	if err := poll.Stop(); err != nil {
-		fmt.Printf("error on shutdown: %v\n", err)
+		dockerd := &mon.DockerD{
+			ControlAddr:      addr,
+			TargetVersion:    cfg.APIVersion,
+			TLS:              tlsConfig,
+			Retry:            cfg.RetryPolicy(),
+			ListTimeoutMs:    config.Ms(cfg.ListTimeout),
+			InspectTimeoutMs: config.Ms(cfg.InspectTimeout),
+			RestartTimeoutMs: config.Ms(cfg.RestartTimeout),
+			RemoveTimeoutMs:  config.Ms(cfg.RemoveTimeout),
+			Metrics:          metrics.WithHost(name),
+			Log:              hostLogger,
+		}
+
+		var api mon.DockerAPI = dockerd
+
+		if cfg.DryRun {
+			api = &mon.DryRunDockerAPI{Dockerd: dockerd, Log: hostLogger}
+		}
+
+		monitor := &mon.Monitor{
+			Host:                  name,
+			Log:                   hostLogger,
+			Dockerd:               api,
+			Selector:              settings.Selector,
+			Metrics:               metrics.WithHost(name),
+			Notifiers:             settings.Notifiers,
+			Concurrency:           settings.Concurrency,
+			Rules:                 settings.Rules,
+			CleanupAfterMs:        settings.CleanupAfterMs,
+			CleanupCreatedAfterMs: settings.CleanupCreatedAfterMs,
+			CleanupDeadAfterMs:    settings.CleanupDeadAfterMs,
+			ImageGC:               settings.ImageGC,
+			ResourceGC:            settings.ResourceGC,
+		}
+
+		host := &mon.Host{
+			Name:    name,
+			Dockerd: dockerd,
+			Monitor: monitor,
+			Poller: &mon.Poller{
+				IntervalMs: config.Ms(cfg.Interval),
+				Handler:    monitor,
+				Log:        hostLogger,
+			},
+		}
+
+		// polling remains as a periodic reconciliation, in case events are missed
+		if cfg.Events {
+			host.Watcher = &mon.EventWatcher{
+				Dockerd: api,
+				Handler: monitor,
+				Log:     hostLogger,
+			}
+		}
+
+		// startup errors trigger immediate exit, but an unreachable daemon isn't one - it's retried like any other
+		if err := host.Start(ctx); err != nil {
+			panic(err)
+		}
+
+		hosts = append(hosts, host)
 	}
-}
 
-// WaitForTERM waits for the SIGTERM signal, then returns
-func WaitForTERM() {
-	sigs := make(chan os.Signal, 1)
-	term := make(chan bool, 1)
+	var health *mon.Health
+	var healthServer *http.Server
 
-	signal.Notify(sigs, syscall.SIGTERM)
+	if len(cfg.HealthAddr) > 0 {
+		listener, err := mon.Listen(cfg.HealthAddr)
+		if err != nil {
+			panic(err)
+		}
 
-	go func() {
-		sig := <-sigs
-		fmt.Printf("Caught %v, stopping", sig)
-		term <- true
-	}()
+		health = mon.NewHealth(hosts, config.Ms(cfg.HealthStale))
+		healthServer = &http.Server{
+			Handler: health,
+		}
 
-	<-term
-}
+		go func() {
+			if err := healthServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logger.WithError(err).Error("Health server failed")
+			}
+		}()
+	}
 
-func envStr(key string) (string, bool) {
-	return os.LookupEnv(key)
-}
+	var adminServer *http.Server
 
-func envInt64(key string) (int64, bool) {
-	if val, ok := os.LookupEnv(key); ok {
-		if i, err := strconv.Atoi(val); err == nil {
-			return int64(i), true
+	if len(cfg.AdminAddr) > 0 {
+		listener, err := mon.Listen(cfg.AdminAddr)
+		if err != nil {
+			panic(err)
 		}
+
+		adminServer = &http.Server{
+			Handler: &mon.Admin{
+				Hosts:   hosts,
+				Log:     logger,
+				Context: ctx,
+			},
+		}
+
+		go func() {
+			if err := adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logger.WithError(err).Error("Admin server failed")
+			}
+		}()
 	}
 
-	return 0, false
-}
+	WaitForSignals(cancel, func() {
+		next, err := config.Load(os.Args[1:], os.LookupEnv)
+		if err != nil {
+			logger.WithError(err).Error("Reload failed, keeping the current config")
+			return
+		}
 
-func envBool(key string) (bool, bool) {
-	if val, ok := os.LookupEnv(key); ok {
-		if b, err := strconv.ParseBool(val); err == nil {
-			return b, true
+		// the rest of next keeps its startup values - dry-run has to, as the api wrappers (and notifiers) follow it
+		changed, err := cfg.RevertRestartOnly(next)
+		if err != nil {
+			logger.WithError(err).Error("Reload failed, keeping the current config")
+			return
+		}
+		for _, name := range changed {
+			logger.With(mon.Fields{"setting": name}).Warn("Setting changed, but only takes effect on a restart")
+		}
+
+		settings := next.MonitorSettings()
+		for _, host := range hosts {
+			host.Monitor.Configure(settings)
+			host.Poller.SetInterval(config.Ms(next.Interval))
+			host.Dockerd.SetRetry(next.RetryPolicy())
+		}
+		if health != nil {
+			health.SetStale(config.Ms(next.HealthStale))
+		}
+
+		logger.With(mon.Fields{
+			"selector":              next.Selector().String(),
+			"interval":              next.Interval,
+			"health_stale":          next.HealthStale,
+			"concurrency":           next.Concurrency,
+			"cleanup_after":         next.CleanupAfter,
+			"cleanup_created_after": next.CleanupCreated,
+			"cleanup_dead_after":    next.CleanupDead,
+			"gc_images":             next.GCImages,
+			"gc_volumes":            next.GCVolumes,
+			"gc_networks":           next.GCNetworks,
+			"retries":               next.Retries,
+			"retry_interval":        next.RetryInterval,
+			"retry_max_elapsed":     next.RetryMaxElapsed,
+			"rules":                 len(next.Rules),
+			"notifiers":             len(next.Notifiers()),
+		}).Info("Reloaded config")
+	})
+
+	if adminServer != nil {
+		if err := adminServer.Close(); err != nil {
+			logger.WithError(err).Error("Error on shutdown")
+		}
+	}
+	for _, host := range hosts {
+		if err := host.Stop(); err != nil {
+			logger.With(mon.Fields{"host": host.Name}).WithError(err).Error("Error on shutdown")
 		}
 	}
+	if metricsServer != nil {
+		if err := metricsServer.Close(); err != nil {
+			logger.WithError(err).Error("Error on shutdown")
+		}
+	}
+	if healthServer != nil {
+		if err := healthServer.Close(); err != nil {
+			logger.WithError(err).Error("Error on shutdown")
+		}
+	}
+}
+
+// WaitForSignals calls reload on each SIGHUP, until SIGTERM - then it cancels in-flight work and returns, once any
+// reload in progress is done. Reloads run one at a time, off the signal loop, as swapping settings in waits on any
+// in-flight poll
+func WaitForSignals(cancel context.CancelFunc, reload func()) {
+	sigs := make(chan os.Signal, 1)
+
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	// a SIGHUP while a reload is pending is covered by that reload, as it reads the config when it starts
+	reloads := make(chan struct{}, 1)
+	reloaded := make(chan struct{})
 
-	return false, false
+	go func() {
+		defer close(reloaded)
+
+		for range reloads {
+			reload()
+		}
+	}()
+
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			logger.With(mon.Fields{"signal": sig}).Info("Caught signal, reloading")
+			select {
+			case reloads <- struct{}{}:
+			default:
+			}
+			continue
+		}
+
+		logger.With(mon.Fields{"signal": sig}).Info("Caught signal, stopping")
+		cancel()
+
+		// the hosts are stopped once we return, which mustn't happen under a reload
+		close(reloads)
+		<-reloaded
+		return
+	}
 }
@@ -0,0 +1,189 @@
+package mon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admin serves a JSON API for inspecting and controlling the running hosts
+type Admin struct {
+	Hosts []*Host
+	Log   *Logger
+	// Context is what requested polls run with, so a client going away doesn't interrupt their actions - nil uses
+	// context.Background()
+	Context context.Context
+}
+
+// containersResponse is the body returned by the container endpoints
+type containersResponse struct {
+	Containers []ContainerStatus `json:"containers"`
+}
+
+// pollerResponse is the body returned by the poller endpoints, Paused is only set if every host's poller is paused,
+// and IntervalMs if every host polls at the same interval
+type pollerResponse struct {
+	Paused     bool                 `json:"paused"`
+	IntervalMs int64                `json:"interval_ms,omitempty"`
+	Hosts      []hostPollerResponse `json:"hosts"`
+}
+
+// hostPollerResponse is the state of a single host's poller
+type hostPollerResponse struct {
+	Host       string `json:"host"`
+	Paused     bool   `json:"paused"`
+	IntervalMs int64  `json:"interval_ms"`
+}
+
+// errorResponse is the body returned for any failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// ServeHTTP routes a request to the matching endpoint, see the README for the full list. Every endpoint acts on all
+// hosts, or just one given by the host query parameter
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hosts := a.Hosts
+	if name := r.URL.Query().Get("host"); len(name) > 0 {
+		host := findHost(a.Hosts, name)
+		if host == nil {
+			a.write(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("Unknown host: '%s'", name)})
+			return
+		}
+
+		hosts = []*Host{host}
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/containers":
+		if a.allow(w, r, http.MethodGet) {
+			a.writeContainers(w, hosts)
+		}
+	case "/poll":
+		if a.allow(w, r, http.MethodPost) {
+			a.poll(a.context(), hosts)
+			a.writeContainers(w, hosts)
+		}
+	case "/poller":
+		if a.allow(w, r, http.MethodGet) {
+			a.writePoller(w, hosts)
+		}
+	case "/poller/pause":
+		if a.allow(w, r, http.MethodPost) {
+			for _, host := range hosts {
+				host.Poller.Pause()
+			}
+			a.writePoller(w, hosts)
+		}
+	case "/poller/resume":
+		if a.allow(w, r, http.MethodPost) {
+			for _, host := range hosts {
+				host.Poller.Resume()
+			}
+			a.writePoller(w, hosts)
+		}
+	default:
+		a.write(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("Not found: '%s'", r.URL.Path)})
+	}
+}
+
+// allow checks the request's method, writing an error if it doesn't match
+func (a *Admin) allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	a.write(w, http.StatusMethodNotAllowed, errorResponse{Error: fmt.Sprintf("Method not allowed: '%s'", r.Method)})
+	return false
+}
+
+// poll polls every host at once, so a slow daemon only holds up the response
+func (a *Admin) poll(ctx context.Context, hosts []*Host) {
+	wg := sync.WaitGroup{}
+
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host *Host) {
+			defer wg.Done()
+
+			a.Log.With(Fields{"host": host.Name}).Info("Poll requested")
+			host.Monitor.Poll(ctx, time.Now())
+		}(host)
+	}
+
+	wg.Wait()
+}
+
+// context is what polls run with, outliving any request
+func (a *Admin) context() context.Context {
+	if a.Context != nil {
+		return a.Context
+	}
+
+	return context.Background()
+}
+
+func (a *Admin) writeContainers(w http.ResponseWriter, hosts []*Host) {
+	res := containersResponse{Containers: []ContainerStatus{}}
+	for _, host := range hosts {
+		res.Containers = append(res.Containers, host.Monitor.Containers()...)
+	}
+
+	a.write(w, http.StatusOK, res)
+}
+
+func (a *Admin) writePoller(w http.ResponseWriter, hosts []*Host) {
+	res := pollerResponse{Paused: len(hosts) > 0}
+
+	for i, host := range hosts {
+		paused := host.Poller.Paused()
+		interval := host.Poller.Interval()
+
+		res.Paused = res.Paused && paused
+		// hosts polling at different intervals are only told apart under hosts
+		if i == 0 {
+			res.IntervalMs = interval
+		} else if res.IntervalMs != interval {
+			res.IntervalMs = 0
+		}
+		res.Hosts = append(res.Hosts, hostPollerResponse{
+			Host:       host.Name,
+			Paused:     paused,
+			IntervalMs: interval,
+		})
+	}
+
+	a.write(w, http.StatusOK, res)
+}
+
+func (a *Admin) write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		a.Log.WithError(err).Warn("Failed to write admin response")
+	}
+}
+
+// Listen opens a listener on addr - either a tcp address ("host:port" or "tcp://host:port"), or a unix socket ("unix:///path")
+func Listen(addr string) (net.Listener, error) {
+	if path := strings.TrimPrefix(addr, "unix://"); path != addr {
+		// a socket left behind by a previous run would stop us binding, but we won't remove anything else
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
+}
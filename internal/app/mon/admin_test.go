@@ -0,0 +1,250 @@
+package mon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+)
+
+// adminRequest makes a request against the admin API, decoding the json response into body
+func adminRequest(t *testing.T, admin *Admin, method string, path string, body interface{}) int {
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+	assert.Equal(t, rec.Header().Get("Content-Type"), "application/json")
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), body))
+
+	return rec.Code
+}
+
+func TestAdminContainers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckHealthLabel,
+		})).
+		Times(1).
+		Return(filterContainers(map[string]string{
+			"mon.observe":       "1",
+			"mon.checks.health": "1",
+		}, testContainers)[:1], nil)
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckCleanupLabel,
+		})).
+		Times(1).
+		Return(testContainers[:1], nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[4])).
+		Times(1).
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(DefaultRestartTimeoutMs), gomock.Eq(testContainers[4])).
+		Times(1).
+		Return(nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[0])).
+		Times(1).
+		Return(testData[0], nil)
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(testContainers[0]), gomock.Eq(types.ContainerRemoveOptions{})).
+		Times(1).
+		Return(context.DeadlineExceeded)
+
+	admin := Admin{
+		Hosts: []*Host{
+			{Name: "local", Monitor: &Monitor{Host: "local", Dockerd: m}, Poller: &Poller{IntervalMs: 100}},
+		},
+	}
+
+	res := containersResponse{}
+	assert.Equal(t, adminRequest(t, &admin, http.MethodGet, "/containers", &res), http.StatusOK)
+	assert.Equal(t, len(res.Containers), 0)
+
+	// polling only happens on POST
+	errRes := errorResponse{}
+	assert.Equal(t, adminRequest(t, &admin, http.MethodGet, "/poll", &errRes), http.StatusMethodNotAllowed)
+
+	assert.Equal(t, adminRequest(t, &admin, http.MethodPost, "/poll", &res), http.StatusOK)
+	assert.Equal(t, len(res.Containers), 2)
+
+	// sorted by name
+	assert.Equal(t, res.Containers[0].Name, "test_cont_abc")
+	assert.Equal(t, res.Containers[0].Host, "local")
+	assert.Equal(t, res.Containers[0].LastCheck.Check, CleanupCheck)
+	assert.Equal(t, res.Containers[0].LastCheck.Result, ResultError)
+	assert.Equal(t, res.Containers[0].LastAction.Action, RemoveAction)
+	assert.Equal(t, res.Containers[0].LastAction.Error, context.DeadlineExceeded.Error())
+
+	assert.Equal(t, res.Containers[1].Name, "test_cont_mno")
+	assert.Equal(t, res.Containers[1].LastCheck.Result, ResultOK)
+	assert.Equal(t, res.Containers[1].LastAction.Action, RestartAction)
+
+	assert.Equal(t, adminRequest(t, &admin, http.MethodGet, "/nope", &errRes), http.StatusNotFound)
+	assert.Equal(t, errRes.Error, "Not found: '/nope'")
+
+	assert.Equal(t, adminRequest(t, &admin, http.MethodGet, "/containers?host=other", &errRes), http.StatusNotFound)
+	assert.Equal(t, errRes.Error, "Unknown host: 'other'")
+}
+
+func TestAdminPollOutlivesRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Times(2).
+		DoAndReturn(func(ctx context.Context, filterList []string) ([]types.Container, error) {
+			assert.NilError(t, ctx.Err())
+			return nil, nil
+		})
+
+	admin := Admin{
+		Hosts: []*Host{
+			{Name: "local", Monitor: &Monitor{Host: "local", Dockerd: m}, Poller: &Poller{IntervalMs: 100}},
+		},
+	}
+
+	// the client's already gone, but the poll isn't interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/poll", nil).WithContext(ctx))
+	assert.Equal(t, rec.Code, http.StatusOK)
+}
+
+func TestAdminHosts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the first host's daemon is stuck, which holds up its poll, but not the second's
+	stuck := mocks.NewMockDockerAPI(ctrl)
+	unblock := make(chan bool)
+	stuck.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Times(2).
+		DoAndReturn(func(ctx context.Context, filterList []string) ([]types.Container, error) {
+			<-unblock
+			return nil, errors.New("test failure")
+		})
+
+	m := mocks.NewMockDockerAPI(ctrl)
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Times(2).
+		Return(nil, nil)
+
+	a := &Host{Name: "a", Monitor: &Monitor{Host: "a", Dockerd: stuck}, Poller: &Poller{IntervalMs: 100}}
+	b := &Host{Name: "b", Monitor: &Monitor{Host: "b", Dockerd: m}, Poller: &Poller{IntervalMs: 100}}
+	admin := Admin{Hosts: []*Host{a, b}}
+
+	polled := make(chan bool)
+	go func() {
+		a.Monitor.Poll(context.Background(), time.Now())
+		close(polled)
+	}()
+
+	res := containersResponse{}
+	assert.Equal(t, adminRequest(t, &admin, http.MethodPost, "/poll?host=b", &res), http.StatusOK)
+	assert.Equal(t, b.Monitor.LastPoll().IsZero(), false)
+	close(unblock)
+	<-polled
+
+	// pausing one host leaves the others polling
+	poller := pollerResponse{}
+	assert.Equal(t, adminRequest(t, &admin, http.MethodPost, "/poller/pause?host=a", &poller), http.StatusOK)
+	assert.Equal(t, poller.Paused, true)
+	assert.Equal(t, a.Poller.Paused(), true)
+	assert.Equal(t, b.Poller.Paused(), false)
+
+	assert.Equal(t, adminRequest(t, &admin, http.MethodGet, "/poller", &poller), http.StatusOK)
+	assert.Equal(t, poller.Paused, false)
+	assert.Equal(t, len(poller.Hosts), 2)
+	assert.Equal(t, poller.Hosts[0].Host, "a")
+	assert.Equal(t, poller.Hosts[0].Paused, true)
+	assert.Equal(t, poller.Hosts[1].Paused, false)
+	assert.Equal(t, poller.IntervalMs, int64(100))
+
+	// the intervals are only given for all hosts at once when they're the same
+	b.Poller.SetInterval(200)
+	poller = pollerResponse{}
+	assert.Equal(t, adminRequest(t, &admin, http.MethodGet, "/poller", &poller), http.StatusOK)
+	assert.Equal(t, poller.IntervalMs, int64(0))
+	assert.Equal(t, poller.Hosts[0].IntervalMs, int64(100))
+	assert.Equal(t, poller.Hosts[1].IntervalMs, int64(200))
+}
+
+func TestAdminPoller(t *testing.T) {
+	admin := Admin{
+		Hosts: []*Host{
+			{Name: "local", Monitor: &Monitor{}, Poller: &Poller{IntervalMs: 100}},
+		},
+	}
+
+	res := pollerResponse{}
+	assert.Equal(t, adminRequest(t, &admin, http.MethodGet, "/poller", &res), http.StatusOK)
+	assert.Equal(t, res.Paused, false)
+	assert.Equal(t, res.IntervalMs, int64(100))
+
+	assert.Equal(t, adminRequest(t, &admin, http.MethodPost, "/poller/pause", &res), http.StatusOK)
+	assert.Equal(t, res.Paused, true)
+	assert.Equal(t, admin.Hosts[0].Poller.Paused(), true)
+
+	assert.Equal(t, adminRequest(t, &admin, http.MethodPost, "/poller/resume/", &res), http.StatusOK)
+	assert.Equal(t, res.Paused, false)
+	assert.Equal(t, admin.Hosts[0].Poller.Paused(), false)
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mon")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "admin.sock")
+
+	listener, err := Listen("unix://" + path)
+	assert.NilError(t, err)
+	assert.Equal(t, listener.Addr().Network(), "unix")
+
+	// a stale socket is replaced
+	listener.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	assert.NilError(t, listener.Close())
+
+	listener, err = Listen("unix://" + path)
+	assert.NilError(t, err)
+	assert.NilError(t, listener.Close())
+
+	// but other files are left alone
+	assert.NilError(t, ioutil.WriteFile(path, []byte("not a socket"), 0644))
+	_, err = Listen("unix://" + path)
+	assert.NotNil(t, err)
+}
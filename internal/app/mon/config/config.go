@@ -0,0 +1,738 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bengreenier/docker-mon/internal/app/mon"
+	"gopkg.in/yaml.v2"
+)
+
+// Source is where a setting's value came from
+type Source string
+
+const (
+	// DefaultSource is a setting nothing else set
+	DefaultSource Source = "default"
+	// FileSource is a setting from the config file
+	FileSource Source = "file"
+	// EnvSource is a setting from a MON_* environment variable
+	EnvSource Source = "env"
+	// FlagSource is a setting from a command-line flag
+	FlagSource Source = "flag"
+)
+
+// EnvPrefix is prepended to a setting's name (upper-cased, with - as _) to find its environment variable
+const EnvPrefix string = "MON_"
+
+// ErrFlags is returned (wrapped) by Load for invalid command-line flags, which have already been reported on stderr,
+// along with the usage
+var ErrFlags = errors.New("invalid flags")
+
+// apiVersionPattern is what a docker API version looks like
+var apiVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// Config is everything mon runs with. Each tagged field is a setting, with a flag, environment variable and
+// config file key of the same name - later sources override earlier ones: defaults < file < env < flags. Settings
+// tagged reload:"false" are only read on startup, a reload keeps their current values (see RevertRestartOnly)
+type Config struct {
+	Control            []string      `config:"control" usage:"Comma-separated docker control sockets to monitor, each can be named like 'name=tcp://host:2375'" reload:"false"`
+	APIVersion         string        `config:"api-version" usage:"Docker API version to use (e.g. '1.37'), negotiated with each daemon when empty" reload:"false"`
+	ConfigPath         string        `config:"config" usage:"Path to a yaml config file, with settings and rules" file:"false" reload:"false"`
+	Prefix             string        `config:"prefix" usage:"Deprecated: use -include=<prefix>*"`
+	Include            []string      `config:"include" usage:"Comma-separated container name patterns to observe - globs, or /regexes/"`
+	Exclude            []string      `config:"exclude" usage:"Comma-separated container name patterns not to observe - globs, or /regexes/"`
+	Images             []string      `config:"images" usage:"Comma-separated image patterns to observe (e.g. 'nginx' matches every tag)"`
+	ComposeProjects    []string      `config:"compose-projects" usage:"Comma-separated compose project patterns to observe"`
+	ComposeServices    []string      `config:"compose-services" usage:"Comma-separated compose service patterns to observe"`
+	LabelSelector      string        `config:"label-selector" usage:"Label expression containers must match (e.g. 'team=payments && env!=dev')"`
+	Interval           time.Duration `config:"interval" usage:"Interval to poll at (e.g. '5s', plain numbers are ms)"`
+	Concurrency        int           `config:"concurrency" usage:"Max number of containers to check (and act on) at once"`
+	Retries            int           `config:"retries" usage:"Max attempts for failed docker commands"`
+	CleanupAfter       time.Duration `config:"cleanup-after" usage:"Time a container has to have exited for before it's cleaned up (e.g. '10m'), unless its mon.checks.cleanup.after label says otherwise"`
+	CleanupCreated     time.Duration `config:"cleanup-created-after" usage:"Time a container has to be stuck in the created state for before it's cleaned up (e.g. '1h'), disabled when 0"`
+	CleanupDead        time.Duration `config:"cleanup-dead-after" usage:"Time a container has to be dead for before it's forcibly cleaned up (e.g. '1h'), disabled when 0"`
+	GCImages           bool          `config:"gc-images" usage:"Remove unused images labelled mon.gc.image=1, or matching -gc-image-patterns, after each poll's cleanup"`
+	GCImagePatterns    []string      `config:"gc-image-patterns" usage:"Comma-separated image patterns (e.g. 'myapp', 'registry.local/*') whose unused tags are removed"`
+	GCImageKeep        int           `config:"gc-image-keep" usage:"Number of the newest images of each repository to keep, even when they're unused"`
+	GCVolumes          bool          `config:"gc-volumes" usage:"Remove unused volumes matching -gc-label-selector, after each poll's cleanup"`
+	GCNetworks         bool          `config:"gc-networks" usage:"Remove unused networks matching -gc-label-selector, after each poll's cleanup"`
+	GCLabelSelector    string        `config:"gc-label-selector" usage:"Label expression volumes and networks must match to be removed, requiring at least one label (e.g. 'com.docker.compose.project=ci')"`
+	GCMinAge           time.Duration `config:"gc-min-age" usage:"Age an unused network has to be before it's removed - or for a volume, time it has to have been unused for"`
+	RetryInterval      time.Duration `config:"retry-interval" usage:"Delay before the first retry of a failed docker command, doubling with each retry"`
+	RetryMaxElapsed    time.Duration `config:"retry-max-elapsed" usage:"Time after which failed docker commands are no longer retried"`
+	ListTimeout        time.Duration `config:"list-timeout" usage:"Timeout for listing containers, including retries" reload:"false"`
+	InspectTimeout     time.Duration `config:"inspect-timeout" usage:"Timeout for inspecting a container, including retries" reload:"false"`
+	RestartTimeout     time.Duration `config:"restart-timeout" usage:"Timeout for restarting a container, including retries, on top of the time it's given to stop" reload:"false"`
+	RemoveTimeout      time.Duration `config:"remove-timeout" usage:"Timeout for removing a container, including retries" reload:"false"`
+	Quiet              bool          `config:"quiet" usage:"Deprecated: use -log-level=info" reload:"false"`
+	LogLevel           string        `config:"log-level" usage:"Minimum level to log at (debug, info, warn or error)" reload:"false"`
+	LogFormat          string        `config:"log-format" usage:"Log output format (text or json)" reload:"false"`
+	Events             bool          `config:"events" usage:"React to docker events between polls" reload:"false"`
+	DryRun             bool          `config:"dry-run" usage:"Log the restarts and removals that would happen, without executing them" reload:"false"`
+	NotifyWebhook      string        `config:"notify-webhook" usage:"URL to post JSON notifications of actions to" secret:"true"`
+	NotifySlack        string        `config:"notify-slack" usage:"Slack-compatible incoming webhook URL to post notifications of actions to" secret:"true"`
+	NotifySMTPAddr     string        `config:"notify-smtp-addr" usage:"SMTP server (host:port) to email notifications of actions through"`
+	NotifySMTPFrom     string        `config:"notify-smtp-from" usage:"Sender address for email notifications"`
+	NotifySMTPTo       string        `config:"notify-smtp-to" usage:"Comma-separated recipient addresses for email notifications"`
+	NotifySMTPUsername string        `config:"notify-smtp-username" usage:"SMTP username for email notifications"`
+	NotifySMTPPassword string        `config:"notify-smtp-password" usage:"SMTP password for email notifications" secret:"true" flag:"false"`
+	MetricsAddr        string        `config:"metrics-addr" usage:"Address to serve prometheus metrics on (e.g. ':9100'), disabled when empty" reload:"false"`
+	HealthAddr         string        `config:"health-addr" usage:"Address or unix socket to serve /healthz and /readyz on, and that 'mon healthcheck' checks, disabled when empty" reload:"false"`
+	HealthStale        time.Duration `config:"health-stale" usage:"Time since the last successful poll (or docker daemon response) after which mon is unhealthy"`
+	TLSCA              string        `config:"tls-ca" usage:"CA certificate to verify tcp:// docker daemons with, defaults to $DOCKER_CERT_PATH/ca.pem (or ~/.docker/ca.pem with just $DOCKER_TLS_VERIFY)" reload:"false"`
+	TLSCert            string        `config:"tls-cert" usage:"Client certificate for tcp:// docker daemons, defaults to $DOCKER_CERT_PATH/cert.pem (or ~/.docker/cert.pem with just $DOCKER_TLS_VERIFY)" reload:"false"`
+	TLSKey             string        `config:"tls-key" usage:"Client key for tcp:// docker daemons, defaults to $DOCKER_CERT_PATH/key.pem (or ~/.docker/key.pem with just $DOCKER_TLS_VERIFY)" reload:"false"`
+	TLSVerify          bool          `config:"tls-verify" usage:"Verify the certificate of tcp:// docker daemons, defaults to whether $DOCKER_TLS_VERIFY is set when $DOCKER_CERT_PATH (or ~/.docker) is used" reload:"false"`
+	AdminAddr          string        `config:"admin-addr" usage:"Address (e.g. '127.0.0.1:9101') or unix socket (e.g. 'unix:///var/run/mon.sock') to serve the admin API on, disabled when empty" reload:"false"`
+
+	// Rules are read from the config file's rules key
+	Rules []mon.Rule
+	// Args are the command-line arguments left after the flags, like a subcommand
+	Args []string
+
+	sources     map[string]Source
+	rulesSource Source
+}
+
+// setting is one tagged field of a Config
+type setting struct {
+	name   string
+	usage  string
+	secret bool
+	flag   bool
+	file   bool
+	// reload is false for settings that only take effect on a restart
+	reload bool
+	value  reflect.Value
+}
+
+// file is the layout of the config file - settings by name, and rules
+type file struct {
+	Rules    []mon.Rule             `yaml:"rules"`
+	Settings map[string]interface{} `yaml:",inline"`
+}
+
+// Defaults is the config before any source is applied
+func Defaults() *Config {
+	return &Config{
+		Control:         []string{"unix:///var/run/docker.sock"},
+		Interval:        5 * time.Second,
+		Concurrency:     mon.DefaultConcurrency,
+		Retries:         int(mon.DefaultRetryAttempts),
+		RetryInterval:   time.Duration(mon.DefaultRetryIntervalMs) * time.Millisecond,
+		RetryMaxElapsed: time.Duration(mon.DefaultRetryMaxElapsedMs) * time.Millisecond,
+		ListTimeout:     time.Duration(mon.DefaultListTimeoutMs) * time.Millisecond,
+		InspectTimeout:  time.Duration(mon.DefaultInspectTimeoutMs) * time.Millisecond,
+		RestartTimeout:  time.Duration(mon.DefaultRestartCallTimeoutMs) * time.Millisecond,
+		RemoveTimeout:   time.Duration(mon.DefaultRemoveTimeoutMs) * time.Millisecond,
+		LogLevel:        "info",
+		LogFormat:       "text",
+		Events:          true,
+		HealthAddr:      mon.DefaultHealthAddr,
+		HealthStale:     time.Duration(mon.DefaultHealthStaleMs) * time.Millisecond,
+		TLSVerify:       true,
+		GCImageKeep:     mon.DefaultGCImageKeep,
+		GCMinAge:        time.Duration(mon.DefaultGCMinAgeMs) * time.Millisecond,
+		sources:         map[string]Source{},
+		rulesSource:     DefaultSource,
+	}
+}
+
+// Load builds the config from every source, and validates it. args are the command-line arguments (without the
+// program name), and lookupEnv is usually os.LookupEnv
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Defaults()
+	settings := c.settings()
+
+	// flags are parsed first, as they say where the file is - but they're applied last
+	flagValues := map[string]string{}
+	flags := flag.NewFlagSet("mon", flag.ContinueOnError)
+	for _, s := range settings {
+		if s.flag {
+			flags.Var(&flagValue{setting: s, values: flagValues}, s.name, s.usage)
+		}
+	}
+
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFlags, err)
+	}
+	c.Args = flags.Args()
+
+	envValues := map[string]string{}
+	for _, s := range settings {
+		if val, ok := lookupEnv(s.envKey()); ok {
+			envValues[s.name] = val
+		}
+	}
+
+	// the docker cli's variables come before all of ours - like it, DOCKER_TLS_VERIFY alone uses the certs in ~/.docker
+	dockerEnvValues := map[string]string{}
+	dockerEnvFrom := "DOCKER_CERT_PATH"
+	certPath, _ := lookupEnv("DOCKER_CERT_PATH")
+	verify, _ := lookupEnv("DOCKER_TLS_VERIFY")
+	if home, ok := lookupEnv("HOME"); len(certPath) == 0 && len(verify) > 0 && ok && len(home) > 0 {
+		certPath = filepath.Join(home, ".docker")
+		dockerEnvFrom = "DOCKER_TLS_VERIFY"
+	}
+	if len(certPath) > 0 {
+		opts := mon.TLSOptionsFromCertPath(certPath, len(verify) > 0)
+
+		dockerEnvValues["tls-ca"] = opts.CAFile
+		dockerEnvValues["tls-cert"] = opts.CertFile
+		dockerEnvValues["tls-key"] = opts.KeyFile
+		dockerEnvValues["tls-verify"] = strconv.FormatBool(opts.Verify)
+	}
+
+	// the file can't say where the file is
+	path := envValues["config"]
+	if val, ok := flagValues["config"]; ok {
+		path = val
+	}
+
+	fileValues, fileLists := map[string]string{}, map[string][]string{}
+	if len(path) > 0 {
+		var err error
+		if fileValues, fileLists, err = c.loadFile(path, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+
+	for _, s := range settings {
+		// a list in the file is taken as is, rather than split on commas
+		if list, ok := fileLists[s.name]; ok {
+			s.value.Set(reflect.ValueOf(list))
+			c.sources[s.name] = FileSource
+		}
+
+		for _, layer := range []struct {
+			source Source
+			values map[string]string
+			from   string
+		}{
+			{EnvSource, dockerEnvValues, dockerEnvFrom},
+			{FileSource, fileValues, path},
+			{EnvSource, envValues, s.envKey()},
+			{FlagSource, flagValues, "-" + s.name},
+		} {
+			raw, ok := layer.values[s.name]
+			if !ok {
+				continue
+			}
+
+			if err := s.set(raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from %s): %v", s.name, layer.from, err))
+				continue
+			}
+
+			c.sources[s.name] = layer.source
+		}
+	}
+
+	c.derive()
+
+	problems = append(problems, c.validate()...)
+	if len(verify) > 0 && len(certPath) == 0 && !c.TLSOptions().Enabled() {
+		problems = append(problems, "tls: DOCKER_TLS_VERIFY is set, but there's no DOCKER_CERT_PATH (or HOME) to find the certs in")
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return c, nil
+}
+
+// loadFile reads the config file's rules into c, and returns its settings as raw values (or lists of them)
+func (c *Config) loadFile(path string, settings []setting) (map[string]string, map[string][]string, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := file{}
+	decoder := yaml.NewDecoder(bytes.NewReader(dat))
+	decoder.SetStrict(true)
+	if err := decoder.Decode(&f); err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("Invalid config file '%s': %w", path, err)
+	}
+
+	if err := mon.ValidateRules(f.Rules); err != nil {
+		return nil, nil, fmt.Errorf("Invalid config file '%s': %w", path, err)
+	}
+
+	if len(f.Rules) > 0 {
+		c.Rules = f.Rules
+		c.rulesSource = FileSource
+	}
+
+	known := map[string]setting{}
+	for _, s := range settings {
+		if s.file {
+			known[s.name] = s
+		}
+	}
+
+	values := map[string]string{}
+	lists := map[string][]string{}
+	for name, val := range f.Settings {
+		s, ok := known[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("Invalid config file '%s': unknown setting '%s'", path, name)
+		}
+
+		switch val := val.(type) {
+		case []interface{}:
+			if s.value.Kind() != reflect.Slice {
+				return nil, nil, fmt.Errorf("Invalid config file '%s': %s must be a single value", path, name)
+			}
+
+			list := []string{}
+			for _, item := range val {
+				list = append(list, fmt.Sprint(item))
+			}
+			lists[name] = list
+		case map[interface{}]interface{}:
+			return nil, nil, fmt.Errorf("Invalid config file '%s': %s must be a single value", path, name)
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(val)
+		}
+	}
+
+	return values, lists, nil
+}
+
+// derive works out the defaults that depend on other settings
+func (c *Config) derive() {
+	// a poll has to be able to finish before mon goes stale, however long the interval
+	if c.Source("health-stale") == DefaultSource {
+		c.HealthStale = time.Duration(mon.DefaultHealthStaleMs) * time.Millisecond
+		if stale := 2*c.Interval + c.ListTimeout; stale > c.HealthStale {
+			c.HealthStale = stale
+		}
+	}
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+
+	if _, err := mon.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("log-level: %v", err))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		problems = append(problems, fmt.Sprintf("log-format: must be text or json, got '%s'", c.LogFormat))
+	}
+
+	for name, positive := range map[string]bool{
+		"interval":     c.Interval > 0,
+		"concurrency":  c.Concurrency > 0,
+		"retries":      c.Retries > 0,
+		"health-stale": c.HealthStale > 0,
+	} {
+		if !positive {
+			problems = append(problems, fmt.Sprintf("%s: must be positive", name))
+		}
+	}
+
+	if c.HealthStale > 0 && c.HealthStale <= c.Interval {
+		problems = append(problems, fmt.Sprintf("health-stale: must be longer than interval (%s), or mon is unhealthy between polls", c.Interval))
+	}
+
+	for name, d := range map[string]time.Duration{
+		"cleanup-after":         c.CleanupAfter,
+		"cleanup-created-after": c.CleanupCreated,
+		"cleanup-dead-after":    c.CleanupDead,
+		"gc-min-age":            c.GCMinAge,
+		"retry-interval":        c.RetryInterval,
+		"retry-max-elapsed":     c.RetryMaxElapsed,
+		"list-timeout":          c.ListTimeout,
+		"inspect-timeout":       c.InspectTimeout,
+		"restart-timeout":       c.RestartTimeout,
+		"remove-timeout":        c.RemoveTimeout,
+	} {
+		if d < 0 {
+			problems = append(problems, fmt.Sprintf("%s: must not be negative", name))
+		}
+	}
+
+	if len(c.Control) == 0 {
+		problems = append(problems, "control: at least one docker control socket is required")
+	}
+
+	names := map[string]bool{}
+	for _, control := range c.Control {
+		name, _ := mon.ParseControl(control)
+		if names[name] {
+			problems = append(problems, fmt.Sprintf("control: more than one host is named '%s', name them like 'name=%s'", name, control))
+		}
+		names[name] = true
+	}
+
+	if len(c.APIVersion) > 0 && !apiVersionPattern.MatchString(c.APIVersion) {
+		problems = append(problems, fmt.Sprintf("api-version: must be like '1.37', got '%s'", c.APIVersion))
+	}
+
+	if opts := c.TLSOptions(); opts.Enabled() {
+		if _, err := opts.Config(); err != nil {
+			problems = append(problems, fmt.Sprintf("tls: %v", err))
+		}
+	}
+
+	if err := c.Selector().Validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("selector: %v", err))
+	}
+
+	if gc := c.ImageGC(); gc != nil {
+		if err := gc.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("gc-images: %v", err))
+		}
+	}
+
+	if gc := c.ResourceGC(); gc != nil {
+		if err := gc.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("gc-label-selector: %v", err))
+		}
+	}
+
+	if len(c.NotifySMTPAddr) > 0 && (len(c.NotifySMTPFrom) == 0 || len(c.NotifySMTPTo) == 0) {
+		problems = append(problems, "notify-smtp-addr: notify-smtp-from and notify-smtp-to are required too")
+	}
+
+	// maps don't iterate in order, but the problems should print the same every time
+	sort.Strings(problems)
+
+	return problems
+}
+
+// Source is where the named setting's value came from
+func (c *Config) Source(name string) Source {
+	if source, ok := c.sources[name]; ok {
+		return source
+	}
+
+	return DefaultSource
+}
+
+// Print writes every setting, its value and where that came from - secrets are masked
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "NAME\tVALUE\tSOURCE")
+	for _, s := range c.settings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.name, s.display(), c.Source(s.name))
+	}
+	fmt.Fprintf(tw, "%s\t%d rule(s)\t%s\n", "rules", len(c.Rules), c.rulesSource)
+
+	return tw.Flush()
+}
+
+// Fields describe the config for logging - secrets are masked
+func (c *Config) Fields() mon.Fields {
+	fields := mon.Fields{
+		"rules": len(c.Rules),
+	}
+
+	for _, s := range c.settings() {
+		key := strings.Replace(s.name, "-", "_", -1)
+		if s.secret {
+			fields[key] = s.display()
+		} else {
+			fields[key] = s.value.Interface()
+		}
+	}
+
+	return fields
+}
+
+// Level is the parsed LogLevel
+func (c *Config) Level() mon.Level {
+	level, _ := mon.ParseLevel(c.LogLevel)
+	return level
+}
+
+// RetryPolicy is the policy for failed docker calls
+func (c *Config) RetryPolicy() mon.RetryPolicy {
+	return mon.RetryPolicy{
+		MaxAttempts:  int64(c.Retries),
+		IntervalMs:   Ms(c.RetryInterval),
+		MaxElapsedMs: Ms(c.RetryMaxElapsed),
+	}
+}
+
+// Notifiers are the configured notifiers, none for a dry run
+func (c *Config) Notifiers() []mon.Notifier {
+	// nothing is actually done in a dry run, so there's nothing to notify anyone of
+	if c.DryRun {
+		return nil
+	}
+
+	var notifiers []mon.Notifier
+
+	if len(c.NotifyWebhook) > 0 {
+		notifiers = append(notifiers, &mon.WebhookNotifier{URL: c.NotifyWebhook})
+	}
+	if len(c.NotifySlack) > 0 {
+		notifiers = append(notifiers, &mon.SlackNotifier{URL: c.NotifySlack})
+	}
+	if len(c.NotifySMTPAddr) > 0 {
+		notifiers = append(notifiers, &mon.EmailNotifier{
+			Addr:     c.NotifySMTPAddr,
+			From:     c.NotifySMTPFrom,
+			To:       strings.Split(c.NotifySMTPTo, ","),
+			Username: c.NotifySMTPUsername,
+			Password: c.NotifySMTPPassword,
+		})
+	}
+
+	return notifiers
+}
+
+// TLSOptions locate the cert material for tcp:// docker daemons, which isn't used unless some is given
+func (c *Config) TLSOptions() mon.TLSOptions {
+	return mon.TLSOptions{
+		CAFile:   c.TLSCA,
+		CertFile: c.TLSCert,
+		KeyFile:  c.TLSKey,
+		Verify:   c.TLSVerify,
+	}
+}
+
+// Selector chooses the containers to observe, nil if every container should be
+func (c *Config) Selector() *mon.Selector {
+	include := c.Include
+	if len(c.Prefix) > 0 {
+		// prefixes were matched against the raw name, which starts with a /
+		include = append(include[:len(include):len(include)], strings.TrimPrefix(c.Prefix, "/")+"*")
+	}
+
+	selector := &mon.Selector{
+		Include:         include,
+		Exclude:         c.Exclude,
+		Images:          c.Images,
+		ComposeProjects: c.ComposeProjects,
+		ComposeServices: c.ComposeServices,
+		Labels:          c.LabelSelector,
+	}
+
+	if len(selector.String()) == 0 {
+		return nil
+	}
+
+	return selector
+}
+
+// ImageGC removes unused images, nil if images should be left alone
+func (c *Config) ImageGC() *mon.ImageGC {
+	if !c.GCImages {
+		return nil
+	}
+
+	return &mon.ImageGC{
+		Patterns: c.GCImagePatterns,
+		Keep:     c.GCImageKeep,
+	}
+}
+
+// ResourceGC removes unused volumes and networks, nil if they should be left alone
+func (c *Config) ResourceGC() *mon.ResourceGC {
+	if !c.GCVolumes && !c.GCNetworks {
+		return nil
+	}
+
+	return &mon.ResourceGC{
+		Labels:   c.GCLabelSelector,
+		Volumes:  c.GCVolumes,
+		Networks: c.GCNetworks,
+		MinAgeMs: Ms(c.GCMinAge),
+	}
+}
+
+// MonitorSettings are the parts of the config a running Monitor can swap in
+func (c *Config) MonitorSettings() mon.MonitorSettings {
+	return mon.MonitorSettings{
+		Selector:              c.Selector(),
+		Rules:                 c.Rules,
+		Notifiers:             c.Notifiers(),
+		Concurrency:           c.Concurrency,
+		CleanupAfterMs:        Ms(c.CleanupAfter),
+		CleanupCreatedAfterMs: Ms(c.CleanupCreated),
+		CleanupDeadAfterMs:    Ms(c.CleanupDead),
+		ImageGC:               c.ImageGC(),
+		ResourceGC:            c.ResourceGC(),
+	}
+}
+
+// RevertRestartOnly sets the settings of next that only take effect on a restart back to those of c, returning the
+// names of the ones that had changed. As what's derived from them can change too, next is validated again
+func (c *Config) RevertRestartOnly(next *Config) ([]string, error) {
+	current := c.settings()
+
+	var changed []string
+	for i, s := range next.settings() {
+		if s.reload || reflect.DeepEqual(s.value.Interface(), current[i].value.Interface()) {
+			continue
+		}
+
+		changed = append(changed, s.name)
+		s.value.Set(current[i].value)
+	}
+
+	next.derive()
+	if problems := next.validate(); len(problems) > 0 {
+		return changed, fmt.Errorf("Invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return changed, nil
+}
+
+// Ms converts a duration to the ms values mon uses
+func Ms(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func (c *Config) settings() []setting {
+	var settings []setting
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, ok := field.Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+
+		settings = append(settings, setting{
+			name:   name,
+			usage:  field.Tag.Get("usage"),
+			secret: field.Tag.Get("secret") == "true",
+			flag:   field.Tag.Get("flag") != "false",
+			file:   field.Tag.Get("file") != "false",
+			reload: field.Tag.Get("reload") != "false",
+			value:  v.Field(i),
+		})
+	}
+
+	return settings
+}
+
+func (s setting) envKey() string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+// set parses raw into the setting's field
+func (s setting) set(raw string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean '%s'", raw)
+		}
+		s.value.SetBool(b)
+	case int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid number '%s'", raw)
+		}
+		s.value.SetInt(int64(i))
+	case time.Duration:
+		d, err := parseDuration(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				list = append(list, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+
+	return nil
+}
+
+// display is the setting's value as it's shown to people
+func (s setting) display() string {
+	if s.secret {
+		if s.value.Len() == 0 {
+			return ""
+		}
+		return "****"
+	}
+
+	if list, ok := s.value.Interface().([]string); ok {
+		return strings.Join(list, ",")
+	}
+
+	return fmt.Sprint(s.value.Interface())
+}
+
+// parseDuration parses a duration like "5s" or "1m30s" - plain numbers are ms, as they always have been
+func parseDuration(raw string) (time.Duration, error) {
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Duration(i) * time.Millisecond, nil
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s', use a number of ms or a value like '5s' or '1m'", raw)
+	}
+
+	return d, nil
+}
+
+// flagValue records a flag's raw value, so it can be applied after the other sources
+type flagValue struct {
+	setting setting
+	values  map[string]string
+}
+
+func (f *flagValue) String() string {
+	// the flag package calls this on a zero value, to tell if the default is worth printing
+	if f.values == nil {
+		return ""
+	}
+
+	return f.setting.display()
+}
+
+func (f *flagValue) Set(raw string) error {
+	// parse now too, so the flag package reports a bad value against the flag
+	if err := f.setting.set(raw); err != nil {
+		return err
+	}
+
+	f.values[f.setting.name] = raw
+	return nil
+}
+
+// IsBoolFlag lets bool settings be passed as just -name
+func (f *flagValue) IsBoolFlag() bool {
+	return f.setting.value.Kind() == reflect.Bool
+}
@@ -0,0 +1,370 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// testEnv looks env up in a map, rather than the real environment
+func testEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}
+}
+
+// testFile writes a config file, returning its path and a cleanup func
+func testFile(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "mon")
+	assert.NilError(t, err)
+
+	path := filepath.Join(dir, "mon.yml")
+	assert.NilError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+
+	assert.Equal(t, c.Interval, 5*time.Second)
+	assert.Equal(t, c.Events, true)
+	assert.Equal(t, c.Source("interval"), DefaultSource)
+	assert.Equal(t, len(c.Rules), 0)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path, cleanup := testFile(t, `
+prefix: from-file
+interval: 1m
+concurrency: 2
+retries: 3
+rules:
+  - match: {name: "web_*"}
+    checks: {health: {}}
+`)
+	defer cleanup()
+
+	c, err := Load([]string{"-retries", "9", "-dry-run", "healthcheck"}, testEnv(map[string]string{
+		"MON_CONFIG":      path,
+		"MON_CONCURRENCY": "7",
+		"MON_RETRIES":     "8",
+	}))
+	assert.NilError(t, err)
+
+	// defaults < file < env < flags
+	assert.EqualStringSlice(t, c.Control, []string{"unix:///var/run/docker.sock"})
+	assert.Equal(t, c.Source("control"), DefaultSource)
+	assert.Equal(t, c.Prefix, "from-file")
+	assert.Equal(t, c.Interval, time.Minute)
+	assert.Equal(t, c.Source("interval"), FileSource)
+	assert.Equal(t, c.Concurrency, 7)
+	assert.Equal(t, c.Source("concurrency"), EnvSource)
+	assert.Equal(t, c.Retries, 9)
+	assert.Equal(t, c.Source("retries"), FlagSource)
+	assert.Equal(t, c.DryRun, true)
+	assert.Equal(t, len(c.Rules), 1)
+	assert.EqualStringSlice(t, c.Args, []string{"healthcheck"})
+}
+
+func TestLoadRules(t *testing.T) {
+	path, cleanup := testFile(t, `
+rules:
+  - name: jobs
+    match: {compose_project: batch}
+    checks: {cleanup: {code: 3}}
+`)
+	defer cleanup()
+
+	c, err := Load([]string{"-config", path}, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, len(c.Rules), 1)
+	assert.Equal(t, *c.Rules[0].Checks.Cleanup.Code, "3")
+
+	// rules are validated as they're loaded, and unknown fields in them are rejected like unknown settings
+	assert.NilError(t, ioutil.WriteFile(path, []byte("rules:\n  - match: {name: a}\n    checks: {health: {timeot: 1}}\n"), 0644))
+	_, err = Load([]string{"-config", path}, testEnv(nil))
+	assert.Error(t, err, "field timeot not found")
+
+	assert.NilError(t, ioutil.WriteFile(path, []byte("rules:\n  - name: nothing\n    match: {name: a}\n"), 0644))
+	_, err = Load([]string{"-config", path}, testEnv(nil))
+	assert.Error(t, err, "rule 1 (nothing): no checks are enabled")
+}
+
+func TestLoadSelector(t *testing.T) {
+	path, cleanup := testFile(t, `
+include: ["web_*", "/^api-[0-9]{2,3}$/"]
+images: nginx
+label-selector: team=payments && env!=dev
+`)
+	defer cleanup()
+
+	c, err := Load([]string{"-exclude", "*_debug, *_test", "-prefix", "/shop"}, testEnv(map[string]string{
+		"MON_CONFIG": path,
+	}))
+	assert.NilError(t, err)
+
+	// lists in the file aren't split on commas, lists anywhere else are
+	assert.EqualStringSlice(t, c.Include, []string{"web_*", "/^api-[0-9]{2,3}$/"})
+	assert.EqualStringSlice(t, c.Images, []string{"nginx"})
+	assert.EqualStringSlice(t, c.Exclude, []string{"*_debug", "*_test"})
+	assert.Equal(t, c.Source("include"), FileSource)
+
+	selector := c.Selector()
+	assert.EqualStringSlice(t, selector.Include, []string{"web_*", "/^api-[0-9]{2,3}$/", "shop*"})
+	assert.Equal(t, selector.Labels, "team=payments && env!=dev")
+
+	c, err = Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.Selector() == nil, true)
+
+	_, err = Load([]string{"-label-selector", "team=("}, testEnv(nil))
+	assert.Error(t, err, "selector: labels: invalid expression")
+
+	_, err = Load([]string{"-include", "/[/"}, testEnv(nil))
+	assert.Error(t, err, "selector: include: invalid pattern")
+}
+
+func TestLoadInvalidFlags(t *testing.T) {
+	// the flag set reports these itself, so they're told apart from other errors
+	_, err := Load([]string{"-interval", "abc"}, testEnv(nil))
+	assert.Equal(t, errors.Is(err, ErrFlags), true)
+
+	_, err = Load([]string{"-nope"}, testEnv(nil))
+	assert.Equal(t, errors.Is(err, ErrFlags), true)
+
+	_, err = Load([]string{"-label-selector", "team=("}, testEnv(nil))
+	assert.Equal(t, errors.Is(err, ErrFlags), false)
+}
+
+func TestLoadAPIVersion(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.APIVersion, "")
+
+	c, err = Load([]string{"-api-version", "1.25"}, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.APIVersion, "1.25")
+
+	_, err = Load(nil, testEnv(map[string]string{"MON_API_VERSION": "v1.25"}))
+	assert.Error(t, err, "api-version: must be like '1.37', got 'v1.25'")
+}
+
+func TestLoadTLS(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.TLSOptions().Enabled(), false)
+
+	// DOCKER_CERT_PATH doesn't point anywhere real, so the cert material is wrong
+	_, err = Load(nil, testEnv(map[string]string{
+		"DOCKER_CERT_PATH": "/nonexistent",
+	}))
+	assert.Error(t, err, "tls: Invalid TLS cert material: Could not load X509 key pair: open /nonexistent/cert.pem")
+
+	// our own settings win over docker's, and verification follows DOCKER_TLS_VERIFY
+	_, err = Load([]string{"-tls-ca", "/other/ca.pem"}, testEnv(map[string]string{
+		"DOCKER_CERT_PATH":  "/nonexistent",
+		"DOCKER_TLS_VERIFY": "1",
+	}))
+	assert.Error(t, err, "could not read CA certificate \"/other/ca.pem\"")
+
+	// like the docker cli, DOCKER_TLS_VERIFY alone uses the certs in ~/.docker
+	_, err = Load(nil, testEnv(map[string]string{
+		"DOCKER_TLS_VERIFY": "1",
+		"HOME":              "/nonexistent",
+	}))
+	assert.Error(t, err, "open /nonexistent/.docker/ca.pem")
+
+	// rather than connecting without TLS
+	_, err = Load(nil, testEnv(map[string]string{"DOCKER_TLS_VERIFY": "1"}))
+	assert.Error(t, err, "DOCKER_TLS_VERIFY is set, but there's no DOCKER_CERT_PATH")
+
+	c = Defaults()
+	c.TLSCert = "/certs/cert.pem"
+	assert.Equal(t, c.TLSOptions().Enabled(), true)
+	assert.Equal(t, c.TLSOptions().Verify, true)
+}
+
+func TestLoadDurations(t *testing.T) {
+	c, err := Load([]string{"-interval", "1500", "-health-stale", "1m30s"}, testEnv(map[string]string{
+		"MON_RETRY_INTERVAL": "250ms",
+		"MON_CLEANUP_AFTER":  "10m",
+	}))
+	assert.NilError(t, err)
+
+	// plain numbers are still ms
+	assert.Equal(t, c.Interval, 1500*time.Millisecond)
+	assert.Equal(t, c.HealthStale, 90*time.Second)
+	assert.Equal(t, c.RetryPolicy().IntervalMs, int64(250))
+	assert.Equal(t, c.MonitorSettings().CleanupAfterMs, int64(10*60*1000))
+	assert.Equal(t, c.MonitorSettings().CleanupDeadAfterMs, int64(0))
+}
+
+func TestLoadImageGC(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.ImageGC() == nil, true)
+
+	c, err = Load([]string{"-gc-images", "-gc-image-patterns", "myapp, registry.local/*"}, testEnv(map[string]string{
+		"MON_GC_IMAGE_KEEP": "3",
+	}))
+	assert.NilError(t, err)
+
+	gc := c.MonitorSettings().ImageGC
+	assert.EqualStringSlice(t, gc.Patterns, []string{"myapp", "registry.local/*"})
+	assert.Equal(t, gc.Keep, 3)
+
+	// patterns aren't checked unless images are collected
+	_, err = Load([]string{"-gc-image-patterns", "/[/"}, testEnv(nil))
+	assert.NilError(t, err)
+
+	_, err = Load([]string{"-gc-images", "-gc-image-patterns", "/[/"}, testEnv(nil))
+	assert.Error(t, err, "gc-images: invalid pattern '/[/'")
+
+	_, err = Load([]string{"-gc-images", "-gc-image-keep", "-1"}, testEnv(nil))
+	assert.Error(t, err, "gc-images: keep: must not be negative, got -1")
+}
+
+func TestLoadResourceGC(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.ResourceGC() == nil, true)
+
+	c, err = Load([]string{"-gc-volumes", "-gc-label-selector", "com.docker.compose.project=ci"}, testEnv(map[string]string{
+		"MON_GC_MIN_AGE": "30m",
+	}))
+	assert.NilError(t, err)
+
+	gc := c.MonitorSettings().ResourceGC
+	assert.Equal(t, gc.Labels, "com.docker.compose.project=ci")
+	assert.Equal(t, gc.Volumes, true)
+	assert.Equal(t, gc.Networks, false)
+	assert.Equal(t, gc.MinAgeMs, int64(30*60*1000))
+
+	// never without a label to go by
+	_, err = Load([]string{"-gc-networks"}, testEnv(nil))
+	assert.Error(t, err, "gc-label-selector: labels: '' has to require at least one label")
+
+	_, err = Load([]string{"-gc-networks", "-gc-label-selector", "env!=prod"}, testEnv(nil))
+	assert.Error(t, err, "gc-label-selector: labels: 'env!=prod' has to require at least one label")
+}
+
+func TestLoadHealthStale(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.HealthStale, time.Minute)
+
+	// the default grows with the interval, to cover a poll (and its list) after the next one is due
+	c, err = Load([]string{"-interval", "2m"}, testEnv(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, c.HealthStale, 4*time.Minute+30*time.Second)
+	assert.Equal(t, c.Source("health-stale"), DefaultSource)
+
+	_, err = Load([]string{"-interval", "2m", "-health-stale", "90s"}, testEnv(nil))
+	assert.Error(t, err, "health-stale: must be longer than interval (2m0s), or mon is unhealthy between polls")
+}
+
+func TestLoadInvalid(t *testing.T) {
+	// bad values aren't ignored, and every problem is reported at once
+	_, err := Load(nil, testEnv(map[string]string{
+		"MON_INTERVAL":    "5x",
+		"MON_CONCURRENCY": "0",
+		"MON_LOG_LEVEL":   "loud",
+		"MON_EVENTS":      "yes please",
+	}))
+	assert.Error(t, err, "interval (from MON_INTERVAL): invalid duration '5x'")
+	assert.Error(t, err, "events (from MON_EVENTS): invalid boolean 'yes please'")
+	assert.Error(t, err, "concurrency: must be positive")
+	assert.Error(t, err, "log-level: Unknown log level: 'loud'")
+
+	_, err = Load([]string{"-control", "tcp://a:2375,unix:///a.sock,unix:///b.sock"}, testEnv(nil))
+	assert.Error(t, err, "control: more than one host is named 'local', name them like 'name=unix:///b.sock'")
+
+	_, err = Load([]string{"-control", ""}, testEnv(nil))
+	assert.Error(t, err, "control: at least one docker control socket is required")
+
+	_, err = Load([]string{"-interval", "soon"}, testEnv(nil))
+	assert.Error(t, err, "invalid value \"soon\" for flag -interval")
+
+	path, cleanup := testFile(t, "intervl: 5s\n")
+	defer cleanup()
+
+	_, err = Load([]string{"-config", path}, testEnv(nil))
+	assert.Error(t, err, "unknown setting 'intervl'")
+
+	assert.NilError(t, ioutil.WriteFile(path, []byte("rules:\n  - match: {nme: a}\n"), 0644))
+	_, err = Load([]string{"-config", path}, testEnv(nil))
+	assert.Error(t, err, "field nme not found")
+}
+
+func TestRevertRestartOnly(t *testing.T) {
+	c, err := Load([]string{"-dry-run"}, testEnv(nil))
+	assert.NilError(t, err)
+
+	next, err := Load([]string{"-interval", "10s", "-log-level", "debug"}, testEnv(map[string]string{
+		"MON_CONTROL": "tcp://a:2375",
+	}))
+	assert.NilError(t, err)
+
+	// the settings a reload can't change keep their current values, including dry-run (so notifiers follow it)
+	changed, err := c.RevertRestartOnly(next)
+	assert.NilError(t, err)
+	assert.EqualStringSlice(t, changed, []string{"control", "log-level", "dry-run"})
+	assert.EqualStringSlice(t, next.Control, []string{"unix:///var/run/docker.sock"})
+	assert.Equal(t, next.LogLevel, "info")
+	assert.Equal(t, next.DryRun, true)
+	assert.Equal(t, len(next.Notifiers()), 0)
+	assert.Equal(t, next.Interval, 10*time.Second)
+
+	changed, err = c.RevertRestartOnly(next)
+	assert.NilError(t, err)
+	assert.Equal(t, len(changed), 0)
+}
+
+func TestRevertRestartOnlyHealthStale(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	assert.NilError(t, err)
+
+	// a reloaded interval moves the derived health-stale with it, worked out with the list-timeout that's kept
+	next, err := Load([]string{"-interval", "2m", "-list-timeout", "1m"}, testEnv(nil))
+	assert.NilError(t, err)
+
+	changed, err := c.RevertRestartOnly(next)
+	assert.NilError(t, err)
+	assert.EqualStringSlice(t, changed, []string{"list-timeout"})
+	assert.Equal(t, next.Interval, 2*time.Minute)
+	assert.Equal(t, next.HealthStale, 4*time.Minute+30*time.Second)
+}
+
+func TestPrint(t *testing.T) {
+	c, err := Load([]string{"-prefix", "web"}, testEnv(map[string]string{
+		"MON_NOTIFY_SMTP_PASSWORD": "hunter2",
+	}))
+	assert.NilError(t, err)
+
+	out := bytes.Buffer{}
+	assert.NilError(t, c.Print(&out))
+
+	lines := map[string][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		lines[fields[0]] = fields[1:]
+	}
+
+	assert.EqualStringSlice(t, lines["prefix"], []string{"web", "flag"})
+	assert.EqualStringSlice(t, lines["interval"], []string{"5s", "default"})
+	assert.EqualStringSlice(t, lines["notify-smtp-password"], []string{"****", "env"})
+	assert.EqualStringSlice(t, lines["rules"], []string{"0", "rule(s)", "default"})
+	assert.Equal(t, strings.Contains(out.String(), "hunter2"), false)
+}
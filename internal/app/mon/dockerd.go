@@ -2,40 +2,259 @@ package mon
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
 )
 
 // DockerAPI is something that implements the docker API
 type DockerAPI interface {
-	ExecuteListQuery(filterList []string) ([]types.Container, error)
-	Restart(timeoutMs int64, cont types.Container) error
-	Remove(types.Container) error
-	Inspect(cont types.Container) (types.ContainerJSON, error)
+	ExecuteListQuery(ctx context.Context, filterList []string) ([]types.Container, error)
+	ExecuteHealthQuery(ctx context.Context, filterList []string, health string) ([]types.Container, error)
+	Restart(ctx context.Context, timeoutMs int64, cont types.Container) error
+	Remove(ctx context.Context, cont types.Container, options types.ContainerRemoveOptions) error
+	Inspect(ctx context.Context, cont types.Container) (types.ContainerJSON, error)
+	Events(ctx context.Context, actions []string, since time.Time) (<-chan events.Message, <-chan error)
+	ListImages(ctx context.Context) ([]types.ImageSummary, error)
+	RemoveImage(ctx context.Context, ref string) ([]types.ImageDelete, error)
+	ListVolumes(ctx context.Context, filterList []string) ([]*types.Volume, error)
+	RemoveVolume(ctx context.Context, name string) error
+	ListNetworks(ctx context.Context, filterList []string) ([]types.NetworkResource, error)
+	RemoveNetwork(ctx context.Context, id string) error
 }
 
-// DockerD implements the DockerAPI for the docker daemon
+// DefaultListTimeoutMs is the default timeout for listing containers
+const DefaultListTimeoutMs int64 = 30 * 1000
+
+// DefaultInspectTimeoutMs is the default timeout for inspecting a container
+const DefaultInspectTimeoutMs int64 = 30 * 1000
+
+// DefaultRestartCallTimeoutMs is the default timeout for restarting a container, on top of the time it's given to stop
+const DefaultRestartCallTimeoutMs int64 = 30 * 1000
+
+// DefaultRemoveTimeoutMs is the default timeout for removing a container
+const DefaultRemoveTimeoutMs int64 = 60 * 1000
+
+// DockerD implements the DockerAPI for the docker daemon, sharing one client across all calls
 type DockerD struct {
-	ControlAddr    string
-	TargetVersion  string
-	CommandRetries int64
+	ControlAddr string
+	// TargetVersion is the docker API version to use, empty negotiates one with the daemon
+	TargetVersion string
+	// TLS is used to connect to tcp:// control addresses, nil connects without it
+	TLS *tls.Config
+	// Retry is the policy for failed calls, use SetRetry to change it once calls are being made
+	Retry RetryPolicy
+	// per-operation timeouts (in ms), covering all retries - zero uses the default
+	ListTimeoutMs    int64
+	InspectTimeoutMs int64
+	RestartTimeoutMs int64
+	RemoveTimeoutMs  int64
+	Metrics          *Metrics
+	Log              *Logger
+	cliMu            sync.Mutex
+	cli              *client.Client
+	version          string
+	retryMu          sync.Mutex
+	contactMu        sync.Mutex
+	lastContact      time.Time
+}
+
+func (d *DockerD) withRetry(ctx context.Context, method string, fn func() error) error {
+	// we wrap this so the caller doesn't need to pass d.Retry around, and so every call is measured
+	start := time.Now()
+	attempts := 0
+
+	d.retryMu.Lock()
+	policy := d.Retry
+	d.retryMu.Unlock()
+
+	err := policy.Do(ctx, d.Log.With(Fields{"method": method}), func() error {
+		attempts++
+		return fn()
+	})
+
+	d.Metrics.ObserveDockerCall(method, time.Since(start), err)
+	d.Metrics.CountRetries(method, attempts-1)
+
+	return err
+}
+
+func (d *DockerD) withCli(ctx context.Context, fn func(*client.Client) error) error {
+	cli, err := d.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(cli)
+	if isTransportError(err) {
+		d.resetClient(cli)
+	} else if err == nil || !isContextError(err) {
+		// even an error response means the daemon is there
+		d.contacted()
+	}
+
+	return err
+}
+
+// SetRetry changes the retry policy, for calls made from now on
+func (d *DockerD) SetRetry(policy RetryPolicy) {
+	d.retryMu.Lock()
+	defer d.retryMu.Unlock()
+
+	d.Retry = policy
+}
+
+// LastContact is when the daemon last responded to a call, zero if it never has
+func (d *DockerD) LastContact() time.Time {
+	d.contactMu.Lock()
+	defer d.contactMu.Unlock()
+
+	return d.lastContact
+}
+
+func (d *DockerD) contacted() {
+	d.contactMu.Lock()
+	defer d.contactMu.Unlock()
+
+	d.lastContact = time.Now()
+}
+
+// client returns the shared client, creating it on first use
+func (d *DockerD) client(ctx context.Context) (*client.Client, error) {
+	d.cliMu.Lock()
+	defer d.cliMu.Unlock()
+
+	if d.cli == nil {
+		httpClient, err := d.httpClient()
+		if err != nil {
+			return nil, err
+		}
+
+		version := d.TargetVersion
+		if len(version) == 0 {
+			version = MaxAPIVersion
+		}
+
+		cli, err := client.NewClient(d.ControlAddr, version, httpClient, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// a new client may be talking to a daemon that's been upgraded (or downgraded) since the last one
+		if len(d.TargetVersion) == 0 {
+			if err := d.negotiate(ctx, cli); err != nil {
+				cli.Close()
+				return nil, err
+			}
+		}
+
+		d.cli = cli
+		d.version = cli.ClientVersion()
+	}
+
+	return d.cli, nil
+}
+
+// negotiate pings the daemon, and has the client use the newest API version both speak
+func (d *DockerD) negotiate(ctx context.Context, cli *client.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(DefaultPingTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	d.contacted()
+
+	version := negotiateVersion(ping.APIVersion)
+	cli.UpdateClientVersion(version)
+
+	if version != d.version {
+		d.Log.With(Fields{"api_version": version, "daemon_api_version": ping.APIVersion}).Info("Negotiated docker API version")
+	}
+
+	return nil
+}
+
+// APIVersion is the docker API version in use, negotiating one with the daemon if that hasn't happened yet
+func (d *DockerD) APIVersion(ctx context.Context) (string, error) {
+	if _, err := d.client(ctx); err != nil {
+		return "", err
+	}
+
+	d.cliMu.Lock()
+	defer d.cliMu.Unlock()
+
+	return d.version, nil
+}
+
+// httpClient is the http client for the docker client to use, nil leaves it to build its own
+func (d *DockerD) httpClient() (*http.Client, error) {
+	if d.TLS == nil {
+		return nil, nil
+	}
+
+	proto, addr, _, err := client.ParseHost(d.ControlAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// a unix socket is protected by its permissions, not TLS
+	if proto != "tcp" {
+		return nil, nil
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: d.TLS.Clone(),
+	}
+	if err := sockets.ConfigureTransport(transport, proto, addr); err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: transport}, nil
 }
 
-func (d *DockerD) withRetry(fn func() error) error {
-	// we wrap this so the caller doesn't need to pass d.CommandRetries around
-	return withRetry(d.CommandRetries, fn)
+// resetClient drops the shared client after its transport failed, so the next call reconnects
+func (d *DockerD) resetClient(cli *client.Client) {
+	d.cliMu.Lock()
+	defer d.cliMu.Unlock()
+
+	// another call may have already replaced it
+	if d.cli != cli {
+		return
+	}
+
+	d.Log.Debug("Docker transport failed, reconnecting on next call")
+	cli.Close()
+	d.cli = nil
 }
 
-func (d *DockerD) withCli(fn func(*client.Client) error) error {
-	// we wrap this so the caller doesn't need to pass addr, ver around
-	return withCli(d.ControlAddr, d.TargetVersion, fn)
+// Close releases the shared client
+func (d *DockerD) Close() error {
+	d.cliMu.Lock()
+	defer d.cliMu.Unlock()
+
+	if d.cli == nil {
+		return nil
+	}
+
+	err := d.cli.Close()
+	d.cli = nil
+
+	return err
 }
 
 // ExecuteListQuery to find containers
-func (d *DockerD) ExecuteListQuery(filterList []string) ([]types.Container, error) {
+func (d *DockerD) ExecuteListQuery(ctx context.Context, filterList []string) ([]types.Container, error) {
 	filterArgs := filters.NewArgs()
 
 	for _, val := range filterList {
@@ -44,10 +263,11 @@ func (d *DockerD) ExecuteListQuery(filterList []string) ([]types.Container, erro
 
 	var containerList []types.Container
 
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.ListTimeoutMs, DefaultListTimeoutMs))
+	defer cancel()
 
-	if err := d.withRetry(func() error {
-		return d.withCli(func(cli *client.Client) error {
+	if err := d.withRetry(ctx, "ExecuteListQuery", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
 			data, err := cli.ContainerList(ctx, types.ContainerListOptions{
 				All:     true,
 				Filters: filterArgs,
@@ -68,37 +288,90 @@ func (d *DockerD) ExecuteListQuery(filterList []string) ([]types.Container, erro
 	return containerList, nil
 }
 
+// ExecuteHealthQuery to find containers with the given health status, which needs HealthFilterAPIVersion
+func (d *DockerD) ExecuteHealthQuery(ctx context.Context, filterList []string, health string) ([]types.Container, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("health", health)
+
+	for _, val := range filterList {
+		filterArgs.Add("label", val)
+	}
+
+	var containerList []types.Container
+
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.ListTimeoutMs, DefaultListTimeoutMs))
+	defer cancel()
+
+	if err := d.withRetry(ctx, "ExecuteHealthQuery", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			data, err := cli.ContainerList(ctx, types.ContainerListOptions{
+				Filters: filterArgs,
+			})
+
+			if err != nil {
+				return err
+			}
+
+			containerList = data
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return containerList, nil
+}
+
 // Restart a container
-func (d *DockerD) Restart(timeoutMs int64, cont types.Container) error {
-	ctx := context.Background()
+func (d *DockerD) Restart(ctx context.Context, timeoutMs int64, cont types.Container) error {
+	duration := time.Duration(timeoutMs) * time.Millisecond
 
-	return d.withRetry(func() error {
-		return d.withCli(func(cli *client.Client) error {
-			duration := time.Duration(timeoutMs) * time.Millisecond
+	// the daemon waits up to timeoutMs for the container to stop, before our own timeout starts to matter
+	ctx, cancel := context.WithTimeout(ctx, duration+msOrDefault(d.RestartTimeoutMs, DefaultRestartCallTimeoutMs))
+	defer cancel()
+
+	return d.withRetry(ctx, "Restart", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
 			return cli.ContainerRestart(ctx, cont.ID, &duration)
 		})
 	})
 }
 
-// Remove a container
-func (d *DockerD) Remove(cont types.Container) error {
-	ctx := context.Background()
+// Remove a container. RemoveLinks removes the links other containers have to it first, as docker only removes a
+// single link (rather than the container) with that option
+func (d *DockerD) Remove(ctx context.Context, cont types.Container, options types.ContainerRemoveOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.RemoveTimeoutMs, DefaultRemoveTimeoutMs))
+	defer cancel()
+
+	return d.withRetry(ctx, "Remove", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			if options.RemoveLinks {
+				for _, link := range linkNames(cont) {
+					// an earlier attempt may have got this far already
+					if err := cli.ContainerRemove(ctx, strings.TrimPrefix(link, "/"), types.ContainerRemoveOptions{RemoveLinks: true}); err != nil && !isNotFound(err) {
+						return err
+					}
+				}
+			}
 
-	return d.withRetry(func() error {
-		return d.withCli(func(cli *client.Client) error {
-			return cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{})
+			return cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{
+				RemoveVolumes: options.RemoveVolumes,
+				Force:         options.Force,
+			})
 		})
 	})
 }
 
 // Inspect a container
-func (d *DockerD) Inspect(cont types.Container) (types.ContainerJSON, error) {
-	ctx := context.Background()
-
+func (d *DockerD) Inspect(ctx context.Context, cont types.Container) (types.ContainerJSON, error) {
 	var data types.ContainerJSON
 
-	if err := d.withRetry(func() error {
-		return d.withCli(func(cli *client.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.InspectTimeoutMs, DefaultInspectTimeoutMs))
+	defer cancel()
+
+	if err := d.withRetry(ctx, "Inspect", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
 			output, err := cli.ContainerInspect(ctx, cont.ID)
 			if err != nil {
 				return err
@@ -114,3 +387,179 @@ func (d *DockerD) Inspect(cont types.Container) (types.ContainerJSON, error) {
 
 	return data, nil
 }
+
+// ListImages finds every top-level image
+func (d *DockerD) ListImages(ctx context.Context) ([]types.ImageSummary, error) {
+	var images []types.ImageSummary
+
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.ListTimeoutMs, DefaultListTimeoutMs))
+	defer cancel()
+
+	if err := d.withRetry(ctx, "ListImages", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			data, err := cli.ImageList(ctx, types.ImageListOptions{})
+			if err != nil {
+				return err
+			}
+
+			images = data
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// RemoveImage removes an image reference (a tag, or an ID), deleting the image and its unused parents once nothing
+// else refers to it - an image in use is never forced out
+func (d *DockerD) RemoveImage(ctx context.Context, ref string) ([]types.ImageDelete, error) {
+	var deleted []types.ImageDelete
+
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.RemoveTimeoutMs, DefaultRemoveTimeoutMs))
+	defer cancel()
+
+	if err := d.withRetry(ctx, "RemoveImage", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			data, err := cli.ImageRemove(ctx, ref, types.ImageRemoveOptions{PruneChildren: true})
+			if err != nil {
+				return err
+			}
+
+			deleted = data
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// ListVolumes finds the volumes with the given labels
+func (d *DockerD) ListVolumes(ctx context.Context, filterList []string) ([]*types.Volume, error) {
+	filterArgs := filters.NewArgs()
+
+	for _, val := range filterList {
+		filterArgs.Add("label", val)
+	}
+
+	var volumes []*types.Volume
+
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.ListTimeoutMs, DefaultListTimeoutMs))
+	defer cancel()
+
+	if err := d.withRetry(ctx, "ListVolumes", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			data, err := cli.VolumeList(ctx, filterArgs)
+			if err != nil {
+				return err
+			}
+
+			volumes = data.Volumes
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return volumes, nil
+}
+
+// RemoveVolume removes a volume - one in use is never forced out
+func (d *DockerD) RemoveVolume(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.RemoveTimeoutMs, DefaultRemoveTimeoutMs))
+	defer cancel()
+
+	return d.withRetry(ctx, "RemoveVolume", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			return cli.VolumeRemove(ctx, name, false)
+		})
+	})
+}
+
+// ListNetworks finds the networks with the given labels
+func (d *DockerD) ListNetworks(ctx context.Context, filterList []string) ([]types.NetworkResource, error) {
+	filterArgs := filters.NewArgs()
+
+	for _, val := range filterList {
+		filterArgs.Add("label", val)
+	}
+
+	var networks []types.NetworkResource
+
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.ListTimeoutMs, DefaultListTimeoutMs))
+	defer cancel()
+
+	if err := d.withRetry(ctx, "ListNetworks", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			data, err := cli.NetworkList(ctx, types.NetworkListOptions{Filters: filterArgs})
+			if err != nil {
+				return err
+			}
+
+			networks = data
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return networks, nil
+}
+
+// RemoveNetwork removes a network - docker refuses to remove one with containers connected
+func (d *DockerD) RemoveNetwork(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, msOrDefault(d.RemoveTimeoutMs, DefaultRemoveTimeoutMs))
+	defer cancel()
+
+	return d.withRetry(ctx, "RemoveNetwork", func() error {
+		return d.withCli(ctx, func(cli *client.Client) error {
+			return cli.NetworkRemove(ctx, id)
+		})
+	})
+}
+
+// Events subscribes to container events with the given actions, optionally replaying those after since
+func (d *DockerD) Events(ctx context.Context, actions []string, since time.Time) (<-chan events.Message, <-chan error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("type", events.ContainerEventType)
+
+	for _, val := range actions {
+		filterArgs.Add("event", val)
+	}
+
+	options := types.EventsOptions{
+		Filters: filterArgs,
+	}
+
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+
+	errs := make(chan error, 1)
+
+	// the stream outlives this call, so we can't use withCli - the transport is checked once the stream ends
+	cli, err := d.client(ctx)
+	if err != nil {
+		errs <- err
+		return nil, errs
+	}
+
+	msgs, cliErrs := cli.Events(ctx, options)
+
+	go func() {
+		err := <-cliErrs
+		if isTransportError(err) {
+			d.resetClient(cli)
+		}
+		errs <- err
+	}()
+
+	return msgs, errs
+}
@@ -0,0 +1,184 @@
+package mon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// fakeDaemon serves just enough of the docker API for DockerD, counting the connections made to it
+type fakeDaemon struct {
+	*httptest.Server
+	mu    sync.Mutex
+	conns int
+}
+
+func newFakeDaemon(t *testing.T) *fakeDaemon {
+	d := &fakeDaemon{}
+
+	d.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			json.NewEncoder(w).Encode(testContainers)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			json.NewEncoder(w).Encode(testData[0])
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	d.Server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			d.mu.Lock()
+			d.conns++
+			d.mu.Unlock()
+		}
+	}
+	d.Server.Start()
+
+	return d
+}
+
+func (d *fakeDaemon) controlAddr() string {
+	return "tcp://" + d.Listener.Addr().String()
+}
+
+func (d *fakeDaemon) connCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.conns
+}
+
+func TestDockerDReusesClient(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	defer daemon.Close()
+
+	dockerd := DockerD{
+		ControlAddr:   daemon.controlAddr(),
+		TargetVersion: "1.37",
+		Retry:         RetryPolicy{MaxAttempts: 1},
+	}
+	defer dockerd.Close()
+
+	for i := 0; i < 20; i++ {
+		conts, err := dockerd.ExecuteListQuery(context.Background(), []string{ObserveLabel})
+		assert.NilError(t, err)
+		assert.Equal(t, len(conts), len(testContainers))
+
+		_, err = dockerd.Inspect(context.Background(), conts[0])
+		assert.NilError(t, err)
+
+		assert.NilError(t, dockerd.Remove(context.Background(), conts[0], types.ContainerRemoveOptions{}))
+	}
+
+	assert.Equal(t, daemon.connCount(), 1)
+}
+
+func TestDockerDReconnectsAfterTransportFailure(t *testing.T) {
+	daemon := newFakeDaemon(t)
+
+	dockerd := DockerD{
+		ControlAddr:   daemon.controlAddr(),
+		TargetVersion: "1.37",
+		Retry:         RetryPolicy{MaxAttempts: 1},
+	}
+	defer dockerd.Close()
+
+	_, err := dockerd.ExecuteListQuery(context.Background(), []string{ObserveLabel})
+	assert.NilError(t, err)
+
+	first := dockerd.cli
+	contact := dockerd.LastContact()
+	assert.Equal(t, contact.IsZero(), false)
+
+	// with the daemon gone, the call fails and the client is dropped
+	daemon.Close()
+	_, err = dockerd.ExecuteListQuery(context.Background(), []string{ObserveLabel})
+	assert.NotNil(t, err)
+	assert.Equal(t, dockerd.cli == nil, true)
+	assert.Equal(t, dockerd.LastContact(), contact)
+
+	// the next call creates a fresh client
+	_, err = dockerd.Inspect(context.Background(), types.Container{ID: "abc"})
+	assert.NotNil(t, err)
+	assert.Equal(t, dockerd.cli != first, true)
+}
+
+func TestDockerDRemoveOptions(t *testing.T) {
+	var mu sync.Mutex
+	var removed []string
+
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		removed = append(removed, r.URL.Path[strings.Index(r.URL.Path, "/containers/"):]+"?"+r.URL.RawQuery)
+		mu.Unlock()
+
+		// the first link is already gone, from an earlier attempt
+		if strings.HasSuffix(r.URL.Path, "/web/db") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "No such container: /web/db"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer daemon.Close()
+
+	dockerd := DockerD{
+		ControlAddr:   "tcp://" + daemon.Listener.Addr().String(),
+		TargetVersion: "1.37",
+		Retry:         RetryPolicy{MaxAttempts: 1},
+	}
+	defer dockerd.Close()
+
+	cont := types.Container{ID: "abc", Names: []string{"/db", "/web/db", "/worker/db"}}
+	assert.NilError(t, dockerd.Remove(context.Background(), cont, types.ContainerRemoveOptions{RemoveVolumes: true, RemoveLinks: true}))
+
+	// the links go first, then the container itself with its volumes
+	mu.Lock()
+	defer mu.Unlock()
+	assert.EqualStringSlice(t, removed, []string{
+		"/containers/web/db?link=1",
+		"/containers/worker/db?link=1",
+		"/containers/abc?v=1",
+	})
+}
+
+func TestDockerDTimeout(t *testing.T) {
+	unblock := make(chan bool)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer hung.Close()
+	defer close(unblock)
+
+	dockerd := DockerD{
+		ControlAddr:      "tcp://" + hung.Listener.Addr().String(),
+		TargetVersion:    "1.37",
+		Retry:            RetryPolicy{MaxAttempts: 3},
+		InspectTimeoutMs: 50,
+	}
+	defer dockerd.Close()
+
+	start := time.Now()
+	_, err := dockerd.Inspect(context.Background(), types.Container{ID: "abc"})
+
+	assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+	assert.Equal(t, time.Since(start) < time.Second, true)
+
+	// cancelling the caller's context works the same way
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = dockerd.ExecuteListQuery(ctx, []string{ObserveLabel})
+	assert.Equal(t, errors.Is(err, context.Canceled), true)
+}
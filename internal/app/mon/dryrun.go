@@ -0,0 +1,177 @@
+package mon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+)
+
+// PollSummarizer is implemented by DockerAPIs that report on what happened during each poll
+type PollSummarizer interface {
+	SummarizePoll(t time.Time)
+}
+
+// PlannedAction is an action the DryRunDockerAPI recorded, rather than executed
+type PlannedAction struct {
+	Action        string
+	ContainerID   string
+	ContainerName string
+	// Object is what an action on something other than a container acts on, like "image nginx:1.19"
+	Object string
+	Reason string
+}
+
+// DryRunDockerAPI wraps a DockerAPI, letting queries through but only logging restarts and removals
+type DryRunDockerAPI struct {
+	Dockerd DockerAPI
+	Log     *Logger
+	mu      sync.Mutex
+	planned []PlannedAction
+}
+
+// ExecuteListQuery to find containers
+func (d *DryRunDockerAPI) ExecuteListQuery(ctx context.Context, filterList []string) ([]types.Container, error) {
+	return d.Dockerd.ExecuteListQuery(ctx, filterList)
+}
+
+// ExecuteHealthQuery to find containers with the given health status
+func (d *DryRunDockerAPI) ExecuteHealthQuery(ctx context.Context, filterList []string, health string) ([]types.Container, error) {
+	return d.Dockerd.ExecuteHealthQuery(ctx, filterList, health)
+}
+
+// APIVersion is the docker API version the wrapped DockerAPI speaks, empty if it doesn't know
+func (d *DryRunDockerAPI) APIVersion(ctx context.Context) (string, error) {
+	if versioner, ok := d.Dockerd.(APIVersioner); ok {
+		return versioner.APIVersion(ctx)
+	}
+
+	return "", nil
+}
+
+// Inspect a container
+func (d *DryRunDockerAPI) Inspect(ctx context.Context, cont types.Container) (types.ContainerJSON, error) {
+	return d.Dockerd.Inspect(ctx, cont)
+}
+
+// Events subscribes to container events
+func (d *DryRunDockerAPI) Events(ctx context.Context, actions []string, since time.Time) (<-chan events.Message, <-chan error) {
+	return d.Dockerd.Events(ctx, actions, since)
+}
+
+// ListImages finds every top-level image
+func (d *DryRunDockerAPI) ListImages(ctx context.Context) ([]types.ImageSummary, error) {
+	return d.Dockerd.ListImages(ctx)
+}
+
+// RemoveImage records that an image reference would be removed, reporting it as deleted so it's logged like it was
+func (d *DryRunDockerAPI) RemoveImage(ctx context.Context, ref string) ([]types.ImageDelete, error) {
+	d.planObject(RemoveImageAction, "image "+ref, "unused")
+	return []types.ImageDelete{{Deleted: ref}}, nil
+}
+
+// ListVolumes finds the volumes with the given labels
+func (d *DryRunDockerAPI) ListVolumes(ctx context.Context, filterList []string) ([]*types.Volume, error) {
+	return d.Dockerd.ListVolumes(ctx, filterList)
+}
+
+// RemoveVolume records that a volume would be removed
+func (d *DryRunDockerAPI) RemoveVolume(ctx context.Context, name string) error {
+	d.planObject(RemoveVolumeAction, "volume "+name, "unused")
+	return nil
+}
+
+// ListNetworks finds the networks with the given labels
+func (d *DryRunDockerAPI) ListNetworks(ctx context.Context, filterList []string) ([]types.NetworkResource, error) {
+	return d.Dockerd.ListNetworks(ctx, filterList)
+}
+
+// RemoveNetwork records that a network would be removed
+func (d *DryRunDockerAPI) RemoveNetwork(ctx context.Context, id string) error {
+	d.planObject(RemoveNetworkAction, "network "+id, "unused")
+	return nil
+}
+
+// Restart records that a container would be restarted
+func (d *DryRunDockerAPI) Restart(ctx context.Context, timeoutMs int64, cont types.Container) error {
+	d.plan(RestartAction, cont)
+	return nil
+}
+
+// Remove records that a container would be removed
+func (d *DryRunDockerAPI) Remove(ctx context.Context, cont types.Container, options types.ContainerRemoveOptions) error {
+	d.plan(RemoveAction, cont)
+	return nil
+}
+
+// Planned returns the actions recorded since the last summary
+func (d *DryRunDockerAPI) Planned() []PlannedAction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]PlannedAction{}, d.planned...)
+}
+
+// SummarizePoll logs the actions recorded since the last summary, and forgets them
+func (d *DryRunDockerAPI) SummarizePoll(t time.Time) {
+	d.mu.Lock()
+	planned := d.planned
+	d.planned = nil
+	d.mu.Unlock()
+
+	// like an uneventful poll, a summary of nothing is only worth logging when debugging
+	logger := d.Log.With(Fields{"poll": t, "planned": len(planned)})
+	if len(planned) == 0 {
+		logger.Debug("Dry run summary")
+		return
+	}
+
+	logger.Info("Dry run summary")
+	for _, p := range planned {
+		logger := d.Log.WithContainer(p.ContainerID, p.ContainerName)
+		if len(p.Object) > 0 {
+			logger = d.Log.With(Fields{"object": p.Object})
+		}
+
+		logger.With(Fields{"action": p.Action, "reason": p.Reason}).Info("Dry run, planned action")
+	}
+}
+
+func (d *DryRunDockerAPI) plan(action string, cont types.Container) {
+	// the status docker reports (e.g. "Up 5 minutes (unhealthy)" or "Exited (0) 3 seconds ago") is why we acted
+	reason := cont.Status
+	if len(reason) == 0 {
+		reason = cont.State
+	}
+
+	p := PlannedAction{
+		Action:        action,
+		ContainerID:   cont.ID,
+		ContainerName: cont.Names[0],
+		Reason:        reason,
+	}
+
+	d.Log.WithContainer(p.ContainerID, p.ContainerName).With(Fields{"action": p.Action, "reason": p.Reason}).Info("Dry run, would act on container")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.planned = append(d.planned, p)
+}
+
+func (d *DryRunDockerAPI) planObject(action string, object string, reason string) {
+	p := PlannedAction{
+		Action: action,
+		Object: object,
+		Reason: reason,
+	}
+
+	d.Log.With(Fields{"object": p.Object, "action": p.Action, "reason": p.Reason}).Info("Dry run, would act on object")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.planned = append(d.planned, p)
+}
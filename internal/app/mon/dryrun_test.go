@@ -0,0 +1,166 @@
+package mon
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+)
+
+func TestDryRunPassesQueriesThrough(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{ObserveLabel})).
+		Times(1).
+		Return(testContainers, nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[0])).
+		Times(1).
+		Return(testData[0], nil)
+
+	dryRun := DryRunDockerAPI{Dockerd: m}
+
+	conts, err := dryRun.ExecuteListQuery(context.Background(), []string{ObserveLabel})
+	assert.NilError(t, err)
+	assert.Equal(t, len(conts), len(testContainers))
+
+	inspect, err := dryRun.Inspect(context.Background(), testContainers[0])
+	assert.NilError(t, err)
+	assert.Equal(t, inspect.ID, testData[0].ID)
+}
+
+func TestDryRunRecordsActions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// no Restart or Remove calls are expected to reach the wrapped api
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	dryRun := DryRunDockerAPI{Dockerd: m}
+
+	unhealthy := testContainers[4]
+	unhealthy.Status = "Up 5 minutes (unhealthy)"
+
+	assert.NilError(t, dryRun.Restart(context.Background(), DefaultRestartTimeoutMs, unhealthy))
+	assert.NilError(t, dryRun.Remove(context.Background(), testContainers[0], types.ContainerRemoveOptions{}))
+
+	assert.DeepEqual(t, dryRun.Planned(), []PlannedAction{
+		{Action: RestartAction, ContainerID: "mno", ContainerName: "test_cont_mno", Reason: "Up 5 minutes (unhealthy)"},
+		{Action: RemoveAction, ContainerID: "abc", ContainerName: "test_cont_abc", Reason: ExitedState},
+	})
+
+	var out bytes.Buffer
+	dryRun.Log = NewLogger(&out, InfoLevel, false)
+
+	dryRun.SummarizePoll(time.Now())
+	assert.Equal(t, len(dryRun.Planned()), 0)
+	assert.Contains(t, out.String(), "Dry run summary")
+
+	// a poll that planned nothing isn't summarized, outside of debug logs
+	out.Reset()
+	dryRun.SummarizePoll(time.Now())
+	assert.Equal(t, out.String(), "")
+}
+
+func TestDryRunRecordsObjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// nothing is removed, only the listing reaches the wrapped api
+	m := mocks.NewMockDockerAPI(ctrl)
+	m.
+		EXPECT().
+		ListImages(gomock.Any()).
+		Times(1).
+		Return(testImages, nil)
+
+	dryRun := DryRunDockerAPI{Dockerd: m}
+
+	images, err := dryRun.ListImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(images), len(testImages))
+
+	deleted, err := dryRun.RemoveImage(context.Background(), "web:2")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, deleted, []types.ImageDelete{{Deleted: "web:2"}})
+	assert.NilError(t, dryRun.RemoveVolume(context.Background(), "ci_data"))
+	assert.NilError(t, dryRun.RemoveNetwork(context.Background(), "net1"))
+
+	assert.DeepEqual(t, dryRun.Planned(), []PlannedAction{
+		{Action: RemoveImageAction, Object: "image web:2", Reason: "unused"},
+		{Action: RemoveVolumeAction, Object: "volume ci_data", Reason: "unused"},
+		{Action: RemoveNetworkAction, Object: "network net1", Reason: "unused"},
+	})
+}
+
+func TestMonitorDryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckHealthLabel,
+		})).
+		Times(1).
+		Return([]types.Container{testContainers[4]}, nil)
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckCleanupLabel,
+		})).
+		Times(1).
+		Return([]types.Container{testContainers[0]}, nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[4])).
+		Times(1).
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[0])).
+		Times(1).
+		Return(testData[0], nil)
+
+	dryRun := DryRunDockerAPI{Dockerd: m}
+	metrics := Metrics{}
+	monitor := Monitor{
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  &dryRun,
+		Metrics:  &metrics,
+	}
+
+	health, _ := monitor.handleContainerHealth(context.Background())
+	cleanup, _ := monitor.handleContainerCleanup(context.Background())
+	assert.Equal(t, len(dryRun.Planned()), 2)
+
+	// the actions are reported as planned, not done
+	results := pollResults{}
+	for _, res := range append(health, cleanup...) {
+		assert.Equal(t, res.planned, true)
+		results.add(res)
+	}
+	assert.DeepEqual(t, results.fields(), Fields{"checked": 2, "busy": 0, "failed": 0, "planned_restart": 1, "planned_remove": 1})
+	for _, status := range monitor.Containers() {
+		assert.Equal(t, status.LastAction.Planned, true)
+	}
+
+	// nothing actually happened, so there's nothing to count
+	assert.Equal(t, strings.Contains(metrics.String(), "mon_actions_total"), false)
+}
@@ -0,0 +1,143 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+// HealthStatusEvent is the action prefix docker uses for healthcheck transitions (e.g. "health_status: unhealthy")
+const HealthStatusEvent string = "health_status"
+
+// DieEvent is the action docker emits when a container process exits
+const DieEvent string = "die"
+
+// StopEvent is the action docker emits when a container is stopped
+const StopEvent string = "stop"
+
+// DestroyEvent is the action docker emits when a container is removed
+const DestroyEvent string = "destroy"
+
+// DefaultReconnectMs is the default delay before re-subscribing to a failed event stream
+const DefaultReconnectMs int64 = 2 * 1000
+
+// WatchedEvents are the container actions the EventWatcher subscribes to
+var WatchedEvents = []string{
+	HealthStatusEvent,
+	DieEvent,
+	StopEvent,
+	DestroyEvent,
+}
+
+// EventHandler provides an event handling method
+type EventHandler interface {
+	HandleEvent(context.Context, events.Message)
+}
+
+// EventWatcher subscribes to the docker event stream, to react to containers between polls
+type EventWatcher struct {
+	Dockerd     DockerAPI
+	Handler     EventHandler
+	ReconnectMs int64
+	Log         *Logger
+	cancel      context.CancelFunc
+	watchDone   chan bool
+	running     bool
+}
+
+// Start begins watching, until Stop is called or ctx is done
+func (w *EventWatcher) Start(ctx context.Context) error {
+	if w.running {
+		return errors.New("Already running")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	w.cancel = cancel
+	w.watchDone = make(chan bool)
+	w.running = true
+
+	go func() {
+		w.watch(ctx)
+		close(w.watchDone)
+	}()
+
+	return nil
+}
+
+// Stop ends watching
+func (w *EventWatcher) Stop() error {
+	if !w.running {
+		return errors.New("Not running")
+	}
+
+	w.cancel()
+	<-w.watchDone
+	w.running = false
+
+	return nil
+}
+
+// watch (re)subscribes to the event stream until ctx is done
+func (w *EventWatcher) watch(ctx context.Context) {
+	reconnectMs := w.ReconnectMs
+	if reconnectMs <= 0 {
+		reconnectMs = DefaultReconnectMs
+	}
+
+	var since time.Time
+
+	for {
+		msgs, errs := w.Dockerd.Events(ctx, WatchedEvents, since)
+		err := w.consume(ctx, msgs, errs, &since)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.Log.With(Fields{"reconnect_ms": reconnectMs}).WithError(err).Warn("Event stream failed, reconnecting")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(reconnectMs) * time.Millisecond):
+		}
+	}
+}
+
+// consume dispatches messages until the stream fails, tracking the last event time so a reconnect can replay from it
+func (w *EventWatcher) consume(ctx context.Context, msgs <-chan events.Message, errs <-chan error, since *time.Time) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-msgs:
+			t := eventTime(msg)
+
+			// replayed events at or before the last one we handled have already been dispatched
+			if !t.After(*since) {
+				continue
+			}
+
+			*since = t
+			w.Handler.HandleEvent(ctx, msg)
+		case err := <-errs:
+			if err == nil {
+				err = io.EOF
+			}
+
+			return err
+		}
+	}
+}
+
+func eventTime(msg events.Message) time.Time {
+	if msg.TimeNano != 0 {
+		return time.Unix(0, msg.TimeNano)
+	}
+
+	return time.Unix(msg.Time, 0)
+}
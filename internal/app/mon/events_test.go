@@ -0,0 +1,89 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+)
+
+type mockEventHandler struct {
+	mu   sync.Mutex
+	msgs []events.Message
+}
+
+func (h *mockEventHandler) HandleEvent(ctx context.Context, msg events.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.msgs = append(h.msgs, msg)
+}
+
+func (h *mockEventHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.msgs)
+}
+
+func TestEventWatcherReconnects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+	handler := mockEventHandler{}
+
+	first := events.Message{Type: events.ContainerEventType, Action: DieEvent, TimeNano: 100}
+	second := events.Message{Type: events.ContainerEventType, Action: StopEvent, TimeNano: 200}
+
+	gomock.InOrder(
+		// the first stream delivers one event, then drops as if the daemon restarted
+		m.
+			EXPECT().
+			Events(gomock.Any(), gomock.Eq(WatchedEvents), gomock.Eq(time.Time{})).
+			Times(1).
+			DoAndReturn(func(ctx context.Context, actions []string, since time.Time) (<-chan events.Message, <-chan error) {
+				msgs := make(chan events.Message, 1)
+				errs := make(chan error, 1)
+				msgs <- first
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					errs <- errors.New("test disconnect")
+				}()
+				return msgs, errs
+			}),
+		// the reconnect resumes from the last event, which is replayed and must not be dispatched twice
+		m.
+			EXPECT().
+			Events(gomock.Any(), gomock.Eq(WatchedEvents), gomock.Eq(eventTime(first))).
+			Times(1).
+			DoAndReturn(func(ctx context.Context, actions []string, since time.Time) (<-chan events.Message, <-chan error) {
+				msgs := make(chan events.Message, 2)
+				msgs <- first
+				msgs <- second
+				return msgs, make(chan error)
+			}),
+	)
+
+	watcher := EventWatcher{
+		Dockerd:     m,
+		Handler:     &handler,
+		ReconnectMs: 10,
+	}
+
+	assert.NilError(t, watcher.Start(context.Background()))
+	assert.Error(t, watcher.Start(context.Background()), "Already running")
+	time.Sleep(500 * time.Millisecond)
+	assert.NilError(t, watcher.Stop())
+	assert.Error(t, watcher.Stop(), "Not running")
+
+	assert.Equal(t, handler.count(), 2)
+	assert.Equal(t, handler.msgs[0].Action, DieEvent)
+	assert.Equal(t, handler.msgs[1].Action, StopEvent)
+}
@@ -0,0 +1,113 @@
+package mon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AnyExitCode matches every exit code, in an exit code list
+const AnyExitCode string = "any"
+
+// exitCodeRange is an inclusive range of exit codes, a single code is a range of one
+type exitCodeRange struct {
+	from int
+	to   int
+}
+
+func (r exitCodeRange) contains(code int) bool {
+	return code >= r.from && code <= r.to
+}
+
+// ExitCodeMatcher matches exit codes against a list like "0,3", "0-2", "any" or "!137". A code matches if it's in any
+// of the listed codes (or there are none, only negated ones), and not in any of the negated ones
+type ExitCodeMatcher struct {
+	raw      string
+	include  []exitCodeRange
+	exclude  []exitCodeRange
+	matchAny bool
+}
+
+// ParseExitCodes parses a comma-separated exit code list, where each entry is a code, a range like "0-2", "any", or
+// any of those but "any" negated with a leading "!"
+func ParseExitCodes(raw string) (*ExitCodeMatcher, error) {
+	matcher := &ExitCodeMatcher{raw: raw}
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+
+		if entry == AnyExitCode {
+			matcher.matchAny = true
+			continue
+		}
+
+		negated := strings.HasPrefix(entry, "!")
+		r, err := parseExitCodeRange(strings.TrimPrefix(entry, "!"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit codes '%s': %w", raw, err)
+		}
+
+		if negated {
+			matcher.exclude = append(matcher.exclude, r)
+		} else {
+			matcher.include = append(matcher.include, r)
+		}
+	}
+
+	return matcher, nil
+}
+
+func parseExitCodeRange(entry string) (exitCodeRange, error) {
+	if len(entry) == 0 {
+		return exitCodeRange{}, errors.New("empty entry")
+	}
+
+	// a leading - would be a negative code, which docker doesn't report
+	bounds := strings.SplitN(entry, "-", 2)
+
+	from, err := strconv.Atoi(bounds[0])
+	if err != nil || from < 0 {
+		return exitCodeRange{}, fmt.Errorf("'%s' isn't a code or range", entry)
+	}
+
+	if len(bounds) == 1 {
+		return exitCodeRange{from: from, to: from}, nil
+	}
+
+	to, err := strconv.Atoi(bounds[1])
+	if err != nil || to < 0 {
+		return exitCodeRange{}, fmt.Errorf("'%s' isn't a code or range", entry)
+	}
+	if to < from {
+		return exitCodeRange{}, fmt.Errorf("range '%s' is backwards", entry)
+	}
+
+	return exitCodeRange{from: from, to: to}, nil
+}
+
+// Matches checks if code is one of the matched exit codes
+func (m *ExitCodeMatcher) Matches(code int) bool {
+	for _, r := range m.exclude {
+		if r.contains(code) {
+			return false
+		}
+	}
+
+	if m.matchAny || len(m.include) == 0 {
+		return true
+	}
+
+	for _, r := range m.include {
+		if r.contains(code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String is the exit code list the matcher was parsed from
+func (m *ExitCodeMatcher) String() string {
+	return m.raw
+}
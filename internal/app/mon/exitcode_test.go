@@ -0,0 +1,50 @@
+package mon
+
+import (
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestExitCodeMatcher(t *testing.T) {
+	for raw, cases := range map[string]map[bool][]int{
+		"0":           {true: {0}, false: {1, 3, 137}},
+		"0,3":         {true: {0, 3}, false: {1, 2, 137}},
+		"0-2":         {true: {0, 1, 2}, false: {3, 137}},
+		"any":         {true: {0, 1, 137, 255}},
+		"!137":        {true: {0, 1, 255}, false: {137}},
+		"0-10, !5":    {true: {0, 4, 6, 10}, false: {5, 11}},
+		"any,!1-2":    {true: {0, 3}, false: {1, 2}},
+		" 1 , 255 ":   {true: {1, 255}, false: {0}},
+		"!0,!128-255": {true: {1, 127}, false: {0, 128, 137}},
+	} {
+		matcher, err := ParseExitCodes(raw)
+		assert.NilError(t, err)
+		assert.Equal(t, matcher.String(), raw)
+
+		for want, codes := range cases {
+			for _, code := range codes {
+				if got := matcher.Matches(code); got != want {
+					t.Errorf("%q with code %d: got %t, want %t", raw, code, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestExitCodeMatcherInvalid(t *testing.T) {
+	for raw, msg := range map[string]string{
+		"":      "empty entry",
+		"0,":    "empty entry",
+		"O":     "'O' isn't a code or range",
+		"-1":    "'-1' isn't a code or range",
+		"3-1":   "range '3-1' is backwards",
+		"1-x":   "'1-x' isn't a code or range",
+		"!any":  "'any' isn't a code or range",
+		"0 - 2": "'0 - 2' isn't a code or range",
+	} {
+		_, err := ParseExitCodes(raw)
+		assert.Error(t, err, msg)
+		assert.Error(t, err, "invalid exit codes '"+raw+"'")
+	}
+}
@@ -0,0 +1,467 @@
+package mon
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// GCImageLabel is how we detect images we can remove, once no container uses them
+const GCImageLabel string = "mon.gc.image=1"
+
+// DefaultGCImageKeep is how many of the newest images of each repository are kept by default
+const DefaultGCImageKeep int = 1
+
+// DefaultGCMinAgeMs is how old an unused volume or network has to be by default, before it's removed
+const DefaultGCMinAgeMs int64 = 60 * 60 * 1000
+
+// predefinedNetworks are docker's own networks, which can't be removed
+var predefinedNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+// noneTag is how docker lists an image without any tags
+const noneTag string = "<none>:<none>"
+
+// ImageGC removes images no container uses anymore, that are labelled for it or match one of its patterns
+type ImageGC struct {
+	// Patterns are image references (globs, or /regexes/, as for Selector.Images) to remove the tags of
+	Patterns []string
+	// Keep is how many of the newest images of each repository are kept, even when they're unused
+	Keep int
+}
+
+// Validate checks Keep isn't negative, and every pattern compiles
+func (g *ImageGC) Validate() error {
+	if g.Keep < 0 {
+		return fmt.Errorf("keep: must not be negative, got %d", g.Keep)
+	}
+
+	for _, pattern := range g.Patterns {
+		if _, err := compileImagePattern(pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// ResourceGC removes volumes and networks no container uses anymore, that match its label expression. Resources are
+// only ever removed if they carry the labels the expression requires, so it has to require at least one
+type ResourceGC struct {
+	// Labels is an expression over the resource's labels (as for Selector.Labels), like "com.docker.compose.project=ci"
+	Labels string
+	// Volumes and Networks are the kinds of resources to collect
+	Volumes  bool
+	Networks bool
+	// MinAgeMs is how old a resource has to be, going by when it was created - or for a volume, when mon first saw it
+	// unused
+	MinAgeMs int64
+
+	// the label expression (and the labels it requires) are parsed on first use, so they aren't for every resource
+	once     sync.Once
+	expr     labelExpr
+	required []string
+	err      error
+}
+
+// compile parses the label expression the first time it's called, returning the same result every time after
+func (g *ResourceGC) compile() (labelExpr, []string, error) {
+	g.once.Do(func() {
+		expr, err := parseLabelExpr(g.Labels)
+		if err != nil {
+			g.err = fmt.Errorf("labels: invalid expression '%s': %v", g.Labels, err)
+			return
+		}
+
+		g.expr, g.required = expr, labelFilters(expr)
+	})
+
+	return g.expr, g.required, g.err
+}
+
+// Validate checks MinAgeMs isn't negative, and the label expression parses and requires at least one label
+func (g *ResourceGC) Validate() error {
+	if g.MinAgeMs < 0 {
+		return fmt.Errorf("min age: must not be negative, got %d", g.MinAgeMs)
+	}
+
+	_, required, err := g.compile()
+	if err != nil {
+		return err
+	}
+
+	if len(required) == 0 {
+		return fmt.Errorf("labels: '%s' has to require at least one label, like 'com.docker.compose.project=ci'", g.Labels)
+	}
+
+	return nil
+}
+
+// matches checks if a resource's labels match - never if the expression doesn't require a label, or the resource
+// doesn't carry every label it does
+func (g *ResourceGC) matches(labels map[string]string) bool {
+	expr, required, err := g.compile()
+	if err != nil || len(required) == 0 {
+		return false
+	}
+
+	for _, filter := range required {
+		if !labelsContain(labels, filter) {
+			return false
+		}
+	}
+
+	return expr.eval(labels)
+}
+
+// filters are the label filters docker can list resources by, a subset of what matches checks
+func (g *ResourceGC) filters() []string {
+	_, required, _ := g.compile()
+	return required
+}
+
+// imageRemoval is an image to collect, and the references to remove to do it
+type imageRemoval struct {
+	image types.ImageSummary
+	refs  []string
+}
+
+// plan picks the images to remove from those listed, by the IDs of the images containers use - oldest first
+func (g *ImageGC) plan(images []types.ImageSummary, used map[string]bool) []imageRemoval {
+	kept := g.kept(images)
+
+	// Validate has checked the patterns compile
+	var patterns []*regexp.Regexp
+	for _, pattern := range g.Patterns {
+		if re, err := compileImagePattern(pattern); err == nil {
+			patterns = append(patterns, re)
+		}
+	}
+
+	var removals []imageRemoval
+	for _, image := range images {
+		if used[image.ID] || kept[image.ID] {
+			continue
+		}
+
+		tags := imageTags(image)
+
+		// a labelled image goes entirely, otherwise just the tags that match (so another repo's tag keeps it)
+		if labelsContain(image.Labels, GCImageLabel) {
+			refs := tags
+			if len(refs) == 0 {
+				refs = []string{image.ID}
+			}
+
+			removals = append(removals, imageRemoval{image: image, refs: refs})
+			continue
+		}
+
+		var refs []string
+		for _, tag := range tags {
+			if anyImageMatches(patterns, tag) {
+				refs = append(refs, tag)
+			}
+		}
+
+		if len(refs) > 0 {
+			removals = append(removals, imageRemoval{image: image, refs: refs})
+		}
+	}
+
+	sort.SliceStable(removals, func(i, j int) bool {
+		return removals[i].image.Created < removals[j].image.Created
+	})
+
+	return removals
+}
+
+// kept finds the IDs of the newest Keep images of each repository
+func (g *ImageGC) kept(images []types.ImageSummary) map[string]bool {
+	repos := map[string][]types.ImageSummary{}
+	for _, image := range images {
+		seen := map[string]bool{}
+		for _, tag := range imageTags(image) {
+			repo := imageRepo(tag)
+			if !seen[repo] {
+				seen[repo] = true
+				repos[repo] = append(repos[repo], image)
+			}
+		}
+	}
+
+	kept := map[string]bool{}
+	for _, repoImages := range repos {
+		sort.SliceStable(repoImages, func(i, j int) bool {
+			return repoImages[i].Created > repoImages[j].Created
+		})
+
+		for i := 0; i < g.Keep && i < len(repoImages); i++ {
+			kept[repoImages[i].ID] = true
+		}
+	}
+
+	return kept
+}
+
+// imageTags are the tags of an image, without the placeholder docker lists for an untagged one
+func imageTags(image types.ImageSummary) []string {
+	var tags []string
+	for _, tag := range image.RepoTags {
+		if tag != noneTag {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// collectGarbage runs the ImageGC and ResourceGC (whichever are set), returning a result for each removal. They share
+// a single listing of the containers
+func (m *Monitor) collectGarbage(ctx context.Context) []checkResult {
+	// every container counts, not just those we observe - and nothing is removed when we can't tell what's in use
+	conts, err := m.Dockerd.ExecuteListQuery(ctx, nil)
+	if err != nil {
+		m.Log.WithError(err).Error("ExecuteListQuery failed")
+		return []checkResult{{err: err}}
+	}
+
+	var results []checkResult
+	if m.ImageGC != nil {
+		results = append(results, m.collectImages(ctx, conts)...)
+	}
+	if m.ResourceGC != nil && ctx.Err() == nil {
+		results = append(results, m.collectResources(ctx, conts)...)
+	}
+
+	return results
+}
+
+// collectImages removes the images the ImageGC picks, given every container, returning a result for each
+func (m *Monitor) collectImages(ctx context.Context, conts []types.Container) []checkResult {
+	logger := m.Log.With(Fields{"action": RemoveImageAction})
+
+	images, err := m.Dockerd.ListImages(ctx)
+	if err != nil {
+		logger.WithError(err).Error("ListImages failed")
+		return []checkResult{{err: err}}
+	}
+
+	used := map[string]bool{}
+	for _, cont := range conts {
+		used[cont.ImageID] = true
+	}
+
+	var results []checkResult
+	for _, removal := range m.ImageGC.plan(images, used) {
+		imageLogger := logger.With(Fields{"image": removal.image.ID, "refs": removal.refs})
+
+		var err error
+		deleted := 0
+		for _, ref := range removal.refs {
+			var res []types.ImageDelete
+			if res, err = m.Dockerd.RemoveImage(ctx, ref); err != nil {
+				break
+			}
+
+			for _, r := range res {
+				if len(r.Deleted) > 0 {
+					deleted++
+				}
+			}
+		}
+
+		// the image is only gone (and its size reclaimed) once its last reference is
+		size := int64(0)
+		if deleted > 0 {
+			size = removal.image.Size
+		}
+		m.actionMetrics().CountGC("image", size, err)
+
+		switch {
+		case err != nil:
+			imageLogger.WithError(err).Error("Failed to remove image")
+		case m.dryRun():
+			imageLogger.With(Fields{"size": removal.image.Size}).Info("Image would be removed")
+		case deleted == 0:
+			imageLogger.Info("Image untagged, it's still referenced otherwise")
+		default:
+			imageLogger.With(Fields{"deleted": deleted, "size": size}).Info("Image removed")
+		}
+
+		results = append(results, checkResult{id: removal.image.ID, action: RemoveImageAction, planned: m.dryRun(), err: err})
+	}
+
+	return results
+}
+
+// gcResource is a volume or network that could be collected
+type gcResource struct {
+	kind   string
+	action string
+	id     string
+	name   string
+	labels map[string]string
+	used   bool
+	// created is zero for volumes, which the API doesn't give a creation time for
+	created time.Time
+}
+
+// key identifies the resource in Monitor.unusedSince
+func (r gcResource) key() string {
+	return r.kind + ":" + r.id
+}
+
+// collectResources removes the volumes and networks the ResourceGC picks, once they've been unused for long enough,
+// given every container, returning a result for each removal
+func (m *Monitor) collectResources(ctx context.Context, conts []types.Container) []checkResult {
+	usedVolumes, usedNetworks := map[string]bool{}, map[string]bool{}
+	for _, cont := range conts {
+		for _, mount := range cont.Mounts {
+			if len(mount.Name) > 0 {
+				usedVolumes[mount.Name] = true
+			}
+		}
+
+		if cont.NetworkSettings != nil {
+			for name, endpoint := range cont.NetworkSettings.Networks {
+				usedNetworks[name] = true
+				if endpoint != nil && len(endpoint.NetworkID) > 0 {
+					usedNetworks[endpoint.NetworkID] = true
+				}
+			}
+		}
+	}
+
+	var resources []gcResource
+
+	// nothing is removed when a list fails, so what's missing from it isn't taken as gone either
+	if m.ResourceGC.Volumes {
+		volumes, err := m.Dockerd.ListVolumes(ctx, m.ResourceGC.filters())
+		if err != nil {
+			m.Log.With(Fields{"action": RemoveVolumeAction}).WithError(err).Error("ListVolumes failed")
+			return []checkResult{{err: err}}
+		}
+
+		for _, volume := range volumes {
+			resources = append(resources, gcResource{
+				kind:   "volume",
+				action: RemoveVolumeAction,
+				id:     volume.Name,
+				name:   volume.Name,
+				labels: volume.Labels,
+				used:   usedVolumes[volume.Name],
+			})
+		}
+	}
+
+	if m.ResourceGC.Networks {
+		networks, err := m.Dockerd.ListNetworks(ctx, m.ResourceGC.filters())
+		if err != nil {
+			m.Log.With(Fields{"action": RemoveNetworkAction}).WithError(err).Error("ListNetworks failed")
+			return []checkResult{{err: err}}
+		}
+
+		for _, network := range networks {
+			if predefinedNetworks[network.Name] {
+				continue
+			}
+
+			resources = append(resources, gcResource{
+				kind:    "network",
+				action:  RemoveNetworkAction,
+				id:      network.ID,
+				name:    network.Name,
+				labels:  network.Labels,
+				used:    usedNetworks[network.ID] || usedNetworks[network.Name] || len(network.Containers) > 0,
+				created: network.Created,
+			})
+		}
+	}
+
+	var results []checkResult
+	for _, res := range m.unusedFor(resources, m.now()) {
+		resLogger := m.Log.With(Fields{"action": res.action, res.kind: res.name})
+
+		var err error
+		if res.kind == "volume" {
+			err = m.Dockerd.RemoveVolume(ctx, res.id)
+		} else {
+			err = m.Dockerd.RemoveNetwork(ctx, res.id)
+		}
+		m.actionMetrics().CountGC(res.kind, 0, err)
+
+		switch {
+		case err != nil:
+			resLogger.WithError(err).Error("Failed to remove unused " + res.kind)
+		case m.dryRun():
+			resLogger.Info("Unused " + res.kind + " would be removed")
+			m.forgetUnused(res)
+		default:
+			resLogger.Info("Unused " + res.kind + " removed")
+			m.forgetUnused(res)
+		}
+
+		results = append(results, checkResult{id: res.id, action: res.action, planned: m.dryRun(), err: err})
+	}
+
+	return results
+}
+
+// unusedFor finds the unused (and matching) resources older than MinAgeMs, by when they were created - or for a volume,
+// which doesn't say, by when it was first seen unused
+func (m *Monitor) unusedFor(resources []gcResource, now time.Time) []gcResource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.unusedSince == nil {
+		m.unusedSince = map[string]time.Time{}
+	}
+
+	seen := map[string]bool{}
+	var due []gcResource
+	for _, res := range resources {
+		// a resource that's in use (or doesn't match anymore) starts over the next time it's unused
+		if res.used || !m.ResourceGC.matches(res.labels) {
+			continue
+		}
+
+		since := res.created
+		if since.IsZero() {
+			seen[res.key()] = true
+
+			var ok bool
+			if since, ok = m.unusedSince[res.key()]; !ok {
+				since = now
+				m.unusedSince[res.key()] = since
+			}
+		}
+
+		remaining := time.Duration(m.ResourceGC.MinAgeMs)*time.Millisecond - now.Sub(since)
+		if remaining > 0 {
+			m.Log.With(Fields{"action": res.action, res.kind: res.name, "remaining": remaining}).Debug("Unused " + res.kind + " kept for now")
+			continue
+		}
+
+		due = append(due, res)
+	}
+
+	for key := range m.unusedSince {
+		if !seen[key] {
+			delete(m.unusedSince, key)
+		}
+	}
+
+	return due
+}
+
+func (m *Monitor) forgetUnused(res gcResource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.unusedSince, res.key())
+}
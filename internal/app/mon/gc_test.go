@@ -0,0 +1,332 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+)
+
+var testImages = []types.ImageSummary{
+	{ID: "sha256:web1", Created: 100, Size: 10, RepoTags: []string{"web:1"}},
+	{ID: "sha256:web2", Created: 200, Size: 20, RepoTags: []string{"web:2"}},
+	{ID: "sha256:web3", Created: 300, Size: 30, RepoTags: []string{"web:3", "registry.local/web:3"}},
+	{ID: "sha256:job", Created: 50, Size: 5, RepoTags: []string{"job:latest"}, Labels: map[string]string{"mon.gc.image": "1"}},
+	{ID: "sha256:dangling", Created: 10, Size: 1, RepoTags: []string{noneTag}, Labels: map[string]string{"mon.gc.image": "1"}},
+	{ID: "sha256:db", Created: 20, Size: 2, RepoTags: []string{"db:1"}},
+}
+
+func removalRefs(removals []imageRemoval) map[string][]string {
+	refs := map[string][]string{}
+	for _, removal := range removals {
+		refs[removal.image.ID] = removal.refs
+	}
+
+	return refs
+}
+
+func TestImageGCValidate(t *testing.T) {
+	assert.NilError(t, (&ImageGC{Patterns: []string{"web", "/^db$/"}}).Validate())
+	assert.Error(t, (&ImageGC{Keep: -1}).Validate(), "keep: must not be negative, got -1")
+	assert.Error(t, (&ImageGC{Patterns: []string{"/[/"}}).Validate(), "invalid pattern '/[/'")
+}
+
+func TestImageGCPlan(t *testing.T) {
+	gc := ImageGC{Patterns: []string{"web"}, Keep: 1}
+
+	removals := gc.plan(testImages, map[string]bool{"sha256:web1": true})
+
+	// web:3 is the newest web, web:1 is used, db doesn't match and job is the newest (and only) job
+	assert.Equal(t, len(removals), 2)
+	assert.Equal(t, removals[0].image.ID, "sha256:dangling")
+	assert.Equal(t, removals[1].image.ID, "sha256:web2")
+
+	// labelled images go by ID if they've no tags
+	refs := removalRefs(removals)
+	assert.EqualStringSlice(t, refs["sha256:dangling"], []string{"sha256:dangling"})
+	assert.EqualStringSlice(t, refs["sha256:web2"], []string{"web:2"})
+}
+
+func TestImageGCPlanKeep(t *testing.T) {
+	gc := ImageGC{Patterns: []string{"registry.local/*"}, Keep: 0}
+
+	// only the tag that matches goes, web:3 keeps the image around
+	refs := removalRefs(gc.plan(testImages, map[string]bool{}))
+	assert.EqualStringSlice(t, refs["sha256:web3"], []string{"registry.local/web:3"})
+	assert.EqualStringSlice(t, refs["sha256:job"], []string{"job:latest"})
+	assert.Equal(t, len(refs["sha256:web2"]), 0)
+
+	// keeping one of each repo keeps the labelled job image too, but not the one without a repo
+	gc.Keep = 1
+	refs = removalRefs(gc.plan(testImages, map[string]bool{}))
+	assert.Equal(t, len(refs["sha256:job"]), 0)
+	assert.Equal(t, len(refs["sha256:web3"]), 0)
+	assert.EqualStringSlice(t, refs["sha256:dangling"], []string{"sha256:dangling"})
+}
+
+func TestMonitorCollectImages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ListImages(gomock.Any()).
+		Times(1).
+		Return(testImages, nil)
+	m.
+		EXPECT().
+		RemoveImage(gomock.Any(), gomock.Eq("sha256:dangling")).
+		Times(1).
+		Return([]types.ImageDelete{{Deleted: "sha256:dangling"}}, nil)
+	m.
+		EXPECT().
+		RemoveImage(gomock.Any(), gomock.Eq("web:2")).
+		Times(1).
+		Return(nil, errors.New("test failure"))
+
+	metrics := Metrics{}
+	monitor := Monitor{
+		Dockerd: m,
+		Metrics: &metrics,
+		ImageGC: &ImageGC{Patterns: []string{"web"}, Keep: 1},
+	}
+
+	results := monitor.collectImages(context.Background(), []types.Container{{ID: "abc", ImageID: "sha256:web1"}})
+	assert.Equal(t, len(results), 2)
+	assert.Equal(t, results[0].action, RemoveImageAction)
+	assert.NilError(t, results[0].err)
+	assert.Error(t, results[1].err, "test failure")
+
+	out := metrics.String()
+	assert.Contains(t, out, `mon_gc_removed_total{kind="image",result="ok"} 1`)
+	assert.Contains(t, out, `mon_gc_removed_total{kind="image",result="error"} 1`)
+	assert.Contains(t, out, `mon_gc_reclaimed_bytes_total{kind="image"} 1`)
+}
+
+func TestMonitorCollectGarbage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	// the containers are listed once, for both
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Nil()).
+		Times(1).
+		Return([]types.Container{{ID: "abc", ImageID: "sha256:web1"}}, nil)
+	m.
+		EXPECT().
+		ListImages(gomock.Any()).
+		Times(1).
+		Return(nil, nil)
+	m.
+		EXPECT().
+		ListVolumes(gomock.Any(), gomock.Any()).
+		Times(1).
+		Return(nil, nil)
+
+	monitor := Monitor{
+		Dockerd:    m,
+		ImageGC:    &ImageGC{Keep: DefaultGCImageKeep},
+		ResourceGC: &ResourceGC{Labels: testComposeProject, Volumes: true},
+	}
+
+	assert.Equal(t, len(monitor.collectGarbage(context.Background())), 0)
+}
+
+func TestMonitorCollectGarbageListFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	// nothing is listed (or removed) when we can't tell what's in use
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Nil()).
+		Times(1).
+		Return(nil, errors.New("test failure"))
+
+	monitor := Monitor{
+		Dockerd:    m,
+		ImageGC:    &ImageGC{Keep: DefaultGCImageKeep},
+		ResourceGC: &ResourceGC{Labels: testComposeProject, Volumes: true, Networks: true},
+	}
+
+	results := monitor.collectGarbage(context.Background())
+	assert.Equal(t, len(results), 1)
+	assert.Error(t, results[0].err, "test failure")
+}
+
+const testComposeProject = "com.docker.compose.project"
+
+var testVolumes = []*types.Volume{
+	{Name: "ci_data", Labels: map[string]string{testComposeProject: "ci"}},
+	{Name: "ci_cache", Labels: map[string]string{testComposeProject: "ci"}},
+	{Name: "ci_keep", Labels: map[string]string{testComposeProject: "ci", "keep": "1"}},
+}
+
+var testNetworks = []types.NetworkResource{
+	{ID: "net1", Name: "ci_default", Labels: map[string]string{testComposeProject: "ci"}},
+	{ID: "net2", Name: "bridge", Labels: map[string]string{testComposeProject: "ci"}},
+}
+
+func TestResourceGCValidate(t *testing.T) {
+	assert.NilError(t, (&ResourceGC{Labels: "com.docker.compose.project=ci && keep!=1"}).Validate())
+	assert.Error(t, (&ResourceGC{Labels: "team=("}).Validate(), "labels: invalid expression 'team=('")
+	assert.Error(t, (&ResourceGC{Labels: ""}).Validate(), "labels: '' has to require at least one label")
+	assert.Error(t, (&ResourceGC{Labels: "a=1 || b=2"}).Validate(), "labels: 'a=1 || b=2' has to require at least one label")
+	assert.Error(t, (&ResourceGC{Labels: "a", MinAgeMs: -1}).Validate(), "min age: must not be negative, got -1")
+}
+
+func TestResourceGCMatches(t *testing.T) {
+	gc := &ResourceGC{Labels: "com.docker.compose.project=ci && keep!=1"}
+
+	assert.Equal(t, gc.matches(testVolumes[0].Labels), true)
+	assert.Equal(t, gc.matches(testVolumes[2].Labels), false)
+	assert.Equal(t, gc.matches(nil), false)
+	assert.EqualStringSlice(t, gc.filters(), []string{"com.docker.compose.project=ci"})
+
+	// an expression that doesn't require a label never matches, even what it would otherwise
+	gc = &ResourceGC{Labels: "keep!=1"}
+	assert.Equal(t, gc.matches(nil), false)
+	assert.Equal(t, gc.matches(testVolumes[0].Labels), false)
+
+	// it's parsed once, and reused for every resource after
+	expr, _, _ := gc.compile()
+	again, _, _ := gc.compile()
+	assert.Equal(t, again == expr, true)
+}
+
+func TestMonitorCollectResources(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	// ci_cache is used by a stopped container, and the network by name
+	conts := []types.Container{{
+		ID:     "abc",
+		Mounts: []types.MountPoint{{Type: "volume", Name: "ci_cache"}},
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{"ci_default": {}},
+		},
+	}}
+	filters := gomock.Eq([]string{"com.docker.compose.project=ci"})
+
+	m.
+		EXPECT().
+		ListVolumes(gomock.Any(), filters).
+		Times(3).
+		Return(testVolumes, nil)
+	m.
+		EXPECT().
+		ListNetworks(gomock.Any(), filters).
+		Times(3).
+		Return(testNetworks, nil)
+	m.
+		EXPECT().
+		RemoveVolume(gomock.Any(), gomock.Eq("ci_data")).
+		Times(1).
+		Return(nil)
+
+	now := time.Unix(1000, 0)
+	metrics := Metrics{}
+	monitor := Monitor{
+		Dockerd:    m,
+		Metrics:    &metrics,
+		ResourceGC: &ResourceGC{Labels: "com.docker.compose.project=ci && keep!=1", Volumes: true, Networks: true, MinAgeMs: 60 * 1000},
+		clock:      func() time.Time { return now },
+	}
+
+	// the first time they're seen unused, they're only noted
+	assert.Equal(t, len(monitor.collectResources(context.Background(), nil)), 0)
+
+	// then ci_cache and the network are used, so they start over
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, len(monitor.collectResources(context.Background(), conts)), 0)
+
+	now = now.Add(30 * time.Second)
+	results := monitor.collectResources(context.Background(), conts)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].id, "ci_data")
+	assert.Equal(t, results[0].action, RemoveVolumeAction)
+	assert.NilError(t, results[0].err)
+
+	assert.Contains(t, metrics.String(), `mon_gc_removed_total{kind="volume",result="ok"} 1`)
+
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	assert.Equal(t, len(monitor.unusedSince), 0)
+}
+
+func TestMonitorCollectNetworksByAge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	now := time.Unix(10000, 0)
+	networks := []types.NetworkResource{
+		{ID: "old", Name: "ci_old", Labels: map[string]string{testComposeProject: "ci"}, Created: now.Add(-2 * time.Minute)},
+		{ID: "new", Name: "ci_new", Labels: map[string]string{testComposeProject: "ci"}, Created: now.Add(-30 * time.Second)},
+	}
+
+	m.
+		EXPECT().
+		ListNetworks(gomock.Any(), gomock.Any()).
+		Times(1).
+		Return(networks, nil)
+	// a network's age is known, so one created long enough ago goes the first time it's seen unused
+	m.
+		EXPECT().
+		RemoveNetwork(gomock.Any(), gomock.Eq("old")).
+		Times(1).
+		Return(nil)
+
+	monitor := Monitor{
+		Dockerd:    m,
+		ResourceGC: &ResourceGC{Labels: testComposeProject, Networks: true, MinAgeMs: 60 * 1000},
+		clock:      func() time.Time { return now },
+	}
+
+	results := monitor.collectResources(context.Background(), nil)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].id, "old")
+
+	// nor is there any need to track when they were first seen unused
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	assert.Equal(t, len(monitor.unusedSince), 0)
+}
+
+func TestMonitorCollectResourcesListFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ListVolumes(gomock.Any(), gomock.Any()).
+		Times(1).
+		Return(nil, errors.New("test failure"))
+
+	monitor := Monitor{
+		Dockerd:    m,
+		ResourceGC: &ResourceGC{Labels: testComposeProject, Volumes: true, Networks: true},
+	}
+
+	results := monitor.collectResources(context.Background(), nil)
+	assert.Equal(t, len(results), 1)
+	assert.Error(t, results[0].err, "test failure")
+}
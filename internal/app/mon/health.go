@@ -0,0 +1,237 @@
+package mon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHealthAddr is the default address the health endpoints are served on
+const DefaultHealthAddr string = "127.0.0.1:9102"
+
+// DefaultHealthStaleMs is the default time since the last successful poll (or daemon round-trip) after which mon is unhealthy
+const DefaultHealthStaleMs int64 = 60 * 1000
+
+// HealthOK is the status reported when healthy (or ready)
+const HealthOK string = "ok"
+
+// HealthFailing is the status reported when unhealthy (or not ready)
+const HealthFailing string = "failing"
+
+// Health serves the liveness (/healthz) and readiness (/readyz) of mon itself
+type Health struct {
+	Hosts []*Host
+	// StaleMs is how old the last successful poll (or daemon round-trip) can get, zero uses the default. Use SetStale
+	// to change it once serving
+	StaleMs int64
+	staleMu sync.Mutex
+	started time.Time
+	clock   func() time.Time
+}
+
+// healthResponse is the body returned by the health endpoints, the times are the latest of any host
+type healthResponse struct {
+	Status      string               `json:"status"`
+	Reason      string               `json:"reason,omitempty"`
+	LastPoll    *time.Time           `json:"last_poll,omitempty"`
+	LastContact *time.Time           `json:"last_contact,omitempty"`
+	Hosts       []hostHealthResponse `json:"hosts,omitempty"`
+}
+
+// hostHealthResponse is the health of a single host
+type hostHealthResponse struct {
+	Host        string     `json:"host"`
+	Status      string     `json:"status"`
+	Reason      string     `json:"reason,omitempty"`
+	LastPoll    *time.Time `json:"last_poll,omitempty"`
+	LastContact *time.Time `json:"last_contact,omitempty"`
+}
+
+// NewHealth creates the health endpoints, with polls considered overdue from now
+func NewHealth(hosts []*Host, staleMs int64) *Health {
+	return &Health{
+		Hosts:   hosts,
+		StaleMs: staleMs,
+		started: time.Now(),
+	}
+}
+
+// ServeHTTP serves /healthz (polls are happening on some host) and /readyz (polls are succeeding on every host, and
+// every daemon is reachable)
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var check func(*Host) string
+	var all bool
+
+	switch r.URL.Path {
+	case "/healthz":
+		// one unreachable daemon shouldn't get mon restarted, when it's still looking after the others
+		check, all = h.liveness, false
+	case "/readyz":
+		check, all = h.readiness, true
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	res := healthResponse{Status: HealthOK}
+	failing := 0
+
+	for _, host := range h.Hosts {
+		hostRes := hostHealthResponse{Host: host.Name, Status: HealthOK, Reason: check(host)}
+
+		if len(hostRes.Reason) > 0 {
+			hostRes.Status = HealthFailing
+			failing++
+
+			if len(res.Reason) == 0 {
+				res.Reason = hostRes.Reason
+				if len(h.Hosts) > 1 {
+					res.Reason = fmt.Sprintf("%s: %s", host.Name, hostRes.Reason)
+				}
+			}
+		}
+
+		if lastPoll := host.Monitor.LastPoll(); !lastPoll.IsZero() {
+			hostRes.LastPoll = &lastPoll
+			if res.LastPoll == nil || lastPoll.After(*res.LastPoll) {
+				res.LastPoll = &lastPoll
+			}
+		}
+		if lastContact := host.Dockerd.LastContact(); !lastContact.IsZero() {
+			hostRes.LastContact = &lastContact
+			if res.LastContact == nil || lastContact.After(*res.LastContact) {
+				res.LastContact = &lastContact
+			}
+		}
+
+		res.Hosts = append(res.Hosts, hostRes)
+	}
+
+	status := http.StatusOK
+	if failing > 0 && (all || failing == len(h.Hosts)) {
+		res.Status = HealthFailing
+		status = http.StatusServiceUnavailable
+	} else {
+		res.Reason = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// liveness is why the host is unhealthy, empty if it's healthy
+func (h *Health) liveness(host *Host) string {
+	// a paused poller isn't stuck
+	if host.Poller.Paused() {
+		return ""
+	}
+
+	// until the first poll succeeds, we give it as long as we'd give any other
+	since := host.Monitor.LastPoll()
+	if since.IsZero() {
+		since = h.started
+	}
+
+	if age := h.now().Sub(since); age > h.stale() {
+		return fmt.Sprintf("No successful poll for %s", age.Round(time.Second))
+	}
+
+	return ""
+}
+
+// readiness is why the host is not ready, empty if it's ready
+func (h *Health) readiness(host *Host) string {
+	if host.Poller.Paused() {
+		return "Polling is paused"
+	}
+
+	lastPoll := host.Monitor.LastPoll()
+	if lastPoll.IsZero() {
+		return "No successful poll yet"
+	}
+	if age := h.now().Sub(lastPoll); age > h.stale() {
+		return fmt.Sprintf("No successful poll for %s", age.Round(time.Second))
+	}
+
+	lastContact := host.Dockerd.LastContact()
+	if lastContact.IsZero() {
+		return "No response from the docker daemon yet"
+	}
+	if age := h.now().Sub(lastContact); age > h.stale() {
+		return fmt.Sprintf("No response from the docker daemon for %s", age.Round(time.Second))
+	}
+
+	return ""
+}
+
+// SetStale changes how old the last successful poll (or daemon round-trip) can get, for checks made from now on
+func (h *Health) SetStale(ms int64) {
+	h.staleMu.Lock()
+	defer h.staleMu.Unlock()
+
+	h.StaleMs = ms
+}
+
+func (h *Health) stale() time.Duration {
+	h.staleMu.Lock()
+	defer h.staleMu.Unlock()
+
+	return msOrDefault(h.StaleMs, DefaultHealthStaleMs)
+}
+
+func (h *Health) now() time.Time {
+	if h.clock != nil {
+		return h.clock()
+	}
+
+	return time.Now()
+}
+
+// CheckHealth asks the mon serving health on addr (as given to Listen) if it's healthy, returning an error if it isn't
+func CheckHealth(addr string, timeout time.Duration) error {
+	if len(addr) == 0 {
+		return errors.New("health-addr is not set, so there's no health endpoint to check")
+	}
+
+	network, address := "tcp", strings.TrimPrefix(addr, "tcp://")
+	if path := strings.TrimPrefix(addr, "unix://"); path != addr {
+		network, address = "unix", path
+	} else if strings.HasPrefix(address, ":") {
+		// listening on all interfaces, so localhost will do
+		address = "127.0.0.1" + address
+	}
+
+	httpClient := http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, address)
+			},
+		},
+	}
+
+	// the host is ignored, we always dial address
+	res, err := httpClient.Get("http://mon/healthz")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body := healthResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fmt.Errorf("Unexpected health response: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unhealthy: %s", body.Reason)
+	}
+
+	return nil
+}
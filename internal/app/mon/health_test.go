@@ -0,0 +1,125 @@
+package mon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func healthRequest(t *testing.T, health *Health, path string) (int, healthResponse) {
+	rec := httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	res := healthResponse{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+
+	return rec.Code, res
+}
+
+func TestHealth(t *testing.T) {
+	now := time.Now()
+	monitor := Monitor{}
+	poller := Poller{}
+	dockerd := DockerD{}
+
+	health := NewHealth([]*Host{{Name: "local", Monitor: &monitor, Poller: &poller, Dockerd: &dockerd}}, 1000)
+	health.clock = func() time.Time {
+		return now
+	}
+
+	// before the first poll, we're healthy but not ready
+	code, res := healthRequest(t, health, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, res.Status, HealthOK)
+
+	code, res = healthRequest(t, health, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, res.Status, HealthFailing)
+	assert.Equal(t, res.Reason, "No successful poll yet")
+
+	monitor.polled(now)
+	code, res = healthRequest(t, health, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, res.Reason, "No response from the docker daemon yet")
+
+	dockerd.contacted()
+	code, res = healthRequest(t, health, "/readyz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, res.LastPoll.Equal(now), true)
+	assert.Equal(t, res.LastContact != nil, true)
+
+	// once polls stop succeeding, we're neither
+	now = now.Add(5 * time.Second)
+	code, res = healthRequest(t, health, "/healthz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, res.Reason, "No successful poll for 5s")
+
+	code, _ = healthRequest(t, health, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+
+	// unless polling was paused on purpose
+	poller.Pause()
+	code, _ = healthRequest(t, health, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+
+	code, res = healthRequest(t, health, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, res.Reason, "Polling is paused")
+}
+
+func TestHealthHosts(t *testing.T) {
+	now := time.Now()
+	a := &Host{Name: "a", Monitor: &Monitor{}, Poller: &Poller{}, Dockerd: &DockerD{}}
+	b := &Host{Name: "b", Monitor: &Monitor{}, Poller: &Poller{}, Dockerd: &DockerD{}}
+
+	health := NewHealth([]*Host{a, b}, 1000)
+	health.clock = func() time.Time {
+		return now
+	}
+
+	a.Monitor.polled(now)
+	a.Dockerd.contacted()
+	b.Monitor.polled(now)
+
+	// every host has to be ready
+	code, res := healthRequest(t, health, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, res.Reason, "b: No response from the docker daemon yet")
+	assert.Equal(t, len(res.Hosts), 2)
+	assert.Equal(t, res.Hosts[0].Status, HealthOK)
+	assert.Equal(t, res.Hosts[1].Status, HealthFailing)
+
+	// but only one has to be polling to be healthy
+	now = now.Add(5 * time.Second)
+	a.Monitor.polled(now)
+
+	code, res = healthRequest(t, health, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, res.Reason, "")
+	assert.Equal(t, res.LastPoll.Equal(now), true)
+	assert.Equal(t, res.Hosts[1].Reason, "No successful poll for 5s")
+
+	now = now.Add(5 * time.Second)
+	code, res = healthRequest(t, health, "/healthz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, res.Reason, "a: No successful poll for 5s")
+}
+
+func TestCheckHealth(t *testing.T) {
+	health := NewHealth([]*Host{{Name: "local", Monitor: &Monitor{}, Poller: &Poller{}, Dockerd: &DockerD{}}}, 1000)
+
+	server := httptest.NewServer(health)
+	defer server.Close()
+
+	assert.NilError(t, CheckHealth(server.Listener.Addr().String(), time.Second))
+
+	health.started = time.Now().Add(-time.Minute)
+	assert.Error(t, CheckHealth("tcp://"+server.Listener.Addr().String(), time.Second), "Unhealthy: No successful poll for 1m0s")
+
+	// rather than failing to dial nowhere
+	assert.Error(t, CheckHealth("", time.Second), "health-addr is not set, so there's no health endpoint to check")
+}
@@ -0,0 +1,91 @@
+package mon
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// LocalHostName is the name of a host reached through a unix socket, unless it's named otherwise
+const LocalHostName string = "local"
+
+// Host is everything mon runs against one docker daemon. Hosts share nothing, so one failing doesn't stall the others
+type Host struct {
+	Name    string
+	Dockerd *DockerD
+	Monitor *Monitor
+	Poller  *Poller
+	// Watcher is nil when events aren't watched
+	Watcher *EventWatcher
+}
+
+// Start starts polling (and watching events on) the host
+func (h *Host) Start(ctx context.Context) error {
+	if err := h.Poller.Start(ctx); err != nil {
+		return err
+	}
+
+	if h.Watcher != nil {
+		return h.Watcher.Start(ctx)
+	}
+
+	return nil
+}
+
+// Stop stops polling and watching the host, and closes its client - returning the first error
+func (h *Host) Stop() error {
+	var errs []error
+
+	if h.Watcher != nil {
+		errs = append(errs, h.Watcher.Stop())
+	}
+	errs = append(errs, h.Poller.Stop(), h.Dockerd.Close())
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseControl splits a control endpoint given as "name=addr" into its name and address, an endpoint without a name
+// is named by HostName
+func ParseControl(control string) (string, string) {
+	// an = after the scheme is part of the address
+	if i := strings.Index(control, "="); i > 0 && !strings.Contains(control[:i], "://") {
+		return control[:i], control[i+1:]
+	}
+
+	return HostName(control), control
+}
+
+// HostName names a host by its control address - "host:port" for tcp, and LocalHostName for a unix socket
+func HostName(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || len(u.Scheme) == 0 {
+		return addr
+	}
+
+	if u.Scheme == "unix" {
+		return LocalHostName
+	}
+
+	if len(u.Host) > 0 {
+		return u.Host
+	}
+
+	return addr
+}
+
+// findHost finds the named host, nil if there isn't one
+func findHost(hosts []*Host, name string) *Host {
+	for _, h := range hosts {
+		if h.Name == name {
+			return h
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package mon
+
+import (
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestParseControl(t *testing.T) {
+	for control, want := range map[string][2]string{
+		"unix:///var/run/docker.sock":           {LocalHostName, "unix:///var/run/docker.sock"},
+		"tcp://10.0.0.2:2375":                   {"10.0.0.2:2375", "tcp://10.0.0.2:2375"},
+		"edge=tcp://10.0.0.3:2376":              {"edge", "tcp://10.0.0.3:2376"},
+		"rootless=unix:///run/user/1000/d.sock": {"rootless", "unix:///run/user/1000/d.sock"},
+		"tcp://proxy:2375/?a=b":                 {"proxy:2375", "tcp://proxy:2375/?a=b"},
+	} {
+		name, addr := ParseControl(control)
+		assert.Equal(t, name, want[0])
+		assert.Equal(t, addr, want[1])
+	}
+}
@@ -0,0 +1,213 @@
+package mon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	// DebugLevel is for routine detail, like each container being checked
+	DebugLevel Level = iota
+	// InfoLevel is for actions taken
+	InfoLevel
+	// WarnLevel is for problems we expect to recover from
+	WarnLevel
+	// ErrorLevel is for failures
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name (debug, info, warn or error)
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+
+	return DebugLevel, fmt.Errorf("Unknown log level: '%s'", s)
+}
+
+// Fields are structured key/values attached to a log entry
+type Fields map[string]interface{}
+
+// defaultLogger is used wherever a Logger isn't configured
+var defaultLogger = NewLogger(os.Stderr, InfoLevel, false)
+
+// Logger writes leveled log entries, with structured fields, as text or json lines
+type Logger struct {
+	Level  Level
+	JSON   bool
+	out    io.Writer
+	mu     *sync.Mutex
+	fields Fields
+}
+
+// NewLogger creates a logger writing to out
+func NewLogger(out io.Writer, level Level, json bool) *Logger {
+	return &Logger{
+		Level: level,
+		JSON:  json,
+		out:   out,
+		mu:    &sync.Mutex{},
+	}
+}
+
+// With returns a logger that adds fields to every entry
+func (l *Logger) With(fields Fields) *Logger {
+	if l == nil {
+		l = defaultLogger
+	}
+
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	return &Logger{
+		Level:  l.Level,
+		JSON:   l.JSON,
+		out:    l.out,
+		mu:     l.mu,
+		fields: merged,
+	}
+}
+
+// WithContainer returns a logger that adds a container's id and name to every entry
+func (l *Logger) WithContainer(id string, name string) *Logger {
+	return l.With(Fields{
+		"container_id":   id,
+		"container_name": name,
+	})
+}
+
+// WithError returns a logger that adds an error to every entry
+func (l *Logger) WithError(err error) *Logger {
+	return l.With(Fields{
+		"error": err,
+	})
+}
+
+// Debug logs at DebugLevel
+func (l *Logger) Debug(msg string) {
+	l.log(DebugLevel, msg)
+}
+
+// Info logs at InfoLevel
+func (l *Logger) Info(msg string) {
+	l.log(InfoLevel, msg)
+}
+
+// Warn logs at WarnLevel
+func (l *Logger) Warn(msg string) {
+	l.log(WarnLevel, msg)
+}
+
+// Error logs at ErrorLevel
+func (l *Logger) Error(msg string) {
+	l.log(ErrorLevel, msg)
+}
+
+func (l *Logger) log(level Level, msg string) {
+	if l == nil {
+		l = defaultLogger
+	}
+
+	if level < l.Level {
+		return
+	}
+
+	var line string
+	if l.JSON {
+		line = l.formatJSON(time.Now(), level, msg)
+	} else {
+		line = l.formatText(time.Now(), level, msg)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintln(l.out, line)
+}
+
+func (l *Logger) formatText(t time.Time, level Level, msg string) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s %-5s %s", t.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg)
+
+	for _, k := range l.sortedFieldKeys() {
+		val := fmt.Sprint(fieldValue(l.fields[k]))
+		if strings.ContainsAny(val, " \t\n\"=") || len(val) == 0 {
+			val = fmt.Sprintf("%q", val)
+		}
+
+		fmt.Fprintf(&sb, " %s=%s", k, val)
+	}
+
+	return sb.String()
+}
+
+func (l *Logger) formatJSON(t time.Time, level Level, msg string) string {
+	entry := map[string]interface{}{}
+	for k, v := range l.fields {
+		entry[k] = fieldValue(v)
+	}
+
+	entry["time"] = t.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	dat, err := json.Marshal(entry)
+	if err != nil {
+		// a field we can't serialize shouldn't cost us the entry
+		return fmt.Sprintf(`{"level":%q,"msg":%q,"log_error":%q}`, level.String(), msg, err.Error())
+	}
+
+	return string(dat)
+}
+
+func (l *Logger) sortedFieldKeys() []string {
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// fieldValue makes values that don't serialize usefully (like errors, or named types like Level) into strings
+func fieldValue(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case error:
+		return typed.Error()
+	case time.Time:
+		return typed.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return typed.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,79 @@
+package mon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("debug")
+	assert.NilError(t, err)
+	assert.Equal(t, level, DebugLevel)
+
+	level, err = ParseLevel("WARN")
+	assert.NilError(t, err)
+	assert.Equal(t, level, WarnLevel)
+
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err, "Unknown log level")
+}
+
+func TestLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, WarnLevel, false)
+
+	logger.Debug("test debug")
+	logger.Info("test info")
+	logger.Warn("test warn")
+	logger.Error("test error")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 2)
+	assert.Contains(t, lines[0], "WARN  test warn")
+	assert.Contains(t, lines[1], "ERROR test error")
+}
+
+func TestLoggerText(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, DebugLevel, false)
+
+	logger.
+		WithContainer("abc", "/test_cont_abc").
+		With(Fields{"check": HealthCheck}).
+		WithError(errors.New("test failure")).
+		Info("Container restarted")
+
+	assert.Contains(t, buf.String(), `INFO  Container restarted check=health container_id=abc container_name=/test_cont_abc error="test failure"`)
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, DebugLevel, true)
+
+	// fields don't leak back into the parent logger
+	child := logger.With(Fields{"action": RestartAction, "level_name": InfoLevel})
+	child.WithError(errors.New("test failure")).Error("Failed to restart unhealthy container")
+	logger.Debug("CheckStart")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 2)
+
+	var entry map[string]interface{}
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, entry["level"], "error")
+	assert.Equal(t, entry["msg"], "Failed to restart unhealthy container")
+	assert.Equal(t, entry["action"], RestartAction)
+	assert.Equal(t, entry["level_name"], "info")
+	assert.Equal(t, entry["error"], "test failure")
+
+	entry = map[string]interface{}{}
+	assert.NilError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, entry["msg"], "CheckStart")
+	_, ok := entry["action"]
+	assert.Equal(t, ok, false)
+}
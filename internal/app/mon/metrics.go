@@ -0,0 +1,258 @@
+package mon
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// labelEscaper escapes label values as the text format expects
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// DurationBuckets are the histogram buckets (in seconds) used for poll and docker call durations
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics collects counters and histograms about what mon is doing, and serves them in the prometheus text format
+type Metrics struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+	// parent is the Metrics series are actually recorded in, with labels added - see WithHost
+	parent *Metrics
+	labels []string
+}
+
+type metricFamily struct {
+	help    string
+	kind    string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+// CountAction records an action taken on a container, and whether it failed
+func (m *Metrics) CountAction(action string, check string, name string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	m.add("mon_actions_total", "Actions taken on containers.", 1,
+		"action", action, "check", check, "container", strings.TrimPrefix(name, "/"), "result", result)
+}
+
+// CountGC records something (like an image) garbage collected, the bytes it reclaimed, and whether it failed
+func (m *Metrics) CountGC(kind string, bytes int64, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	m.add("mon_gc_removed_total", "Unused images, volumes and networks removed.", 1, "kind", kind, "result", result)
+
+	if err == nil && bytes > 0 {
+		m.add("mon_gc_reclaimed_bytes_total", "Bytes reclaimed by removing unused images and volumes.", float64(bytes), "kind", kind)
+	}
+}
+
+// ObservePoll records how long a poll took
+func (m *Metrics) ObservePoll(d time.Duration) {
+	m.observe("mon_poll_duration_seconds", "Time taken to poll all containers.", d.Seconds())
+}
+
+// ObserveDockerCall records how long a docker daemon call took (including retries), and whether it failed
+func (m *Metrics) ObserveDockerCall(method string, d time.Duration, err error) {
+	m.observe("mon_docker_call_duration_seconds", "Time taken by docker daemon calls, including retries.", d.Seconds(),
+		"method", method)
+
+	if err != nil {
+		m.add("mon_docker_call_errors_total", "Docker daemon calls that failed after all retries.", 1,
+			"method", method)
+	}
+}
+
+// CountRetries records retried attempts of a docker daemon call
+func (m *Metrics) CountRetries(method string, retries int) {
+	if retries <= 0 {
+		return
+	}
+
+	m.add("mon_docker_call_retries_total", "Retried attempts of docker daemon calls.", float64(retries),
+		"method", method)
+}
+
+// WithHost returns Metrics recording into m, with every series labelled by host
+func (m *Metrics) WithHost(host string) *Metrics {
+	if m == nil {
+		return nil
+	}
+
+	return &Metrics{
+		parent: m.root(),
+		labels: append(m.labels[:len(m.labels):len(m.labels)], "host", host),
+	}
+}
+
+func (m *Metrics) root() *Metrics {
+	if m.parent != nil {
+		return m.parent
+	}
+
+	return m
+}
+
+// ServeHTTP writes all metrics in the prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, m.String())
+}
+
+// String renders all metrics in the prometheus text format
+func (m *Metrics) String() string {
+	if m == nil {
+		return ""
+	}
+
+	m = m.root()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sb strings.Builder
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := m.families[name]
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&sb, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, family.kind)
+
+		for _, key := range keys {
+			series := family.series[key]
+
+			if family.kind == "counter" {
+				fmt.Fprintf(&sb, "%s%s %s\n", name, wrapLabels(series.labels), formatFloat(series.value))
+				continue
+			}
+
+			for i, le := range family.buckets {
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(series.labels, "le", formatFloat(le))), series.counts[i])
+			}
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(series.labels, "le", "+Inf")), series.count)
+			fmt.Fprintf(&sb, "%s_sum%s %s\n", name, wrapLabels(series.labels), formatFloat(series.value))
+			fmt.Fprintf(&sb, "%s_count%s %d\n", name, wrapLabels(series.labels), series.count)
+		}
+	}
+
+	return sb.String()
+}
+
+// add increments a counter, labels are given as alternating names and values
+func (m *Metrics) add(name string, help string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+
+	labels = append(m.labels[:len(m.labels):len(m.labels)], labels...)
+	m = m.root()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series(name, help, "counter", labels).value += v
+}
+
+// observe adds a value to a histogram, labels are given as alternating names and values
+func (m *Metrics) observe(name string, help string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+
+	labels = append(m.labels[:len(m.labels):len(m.labels)], labels...)
+	m = m.root()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series := m.series(name, help, "histogram", labels)
+	series.value += v
+	series.count++
+
+	for i, le := range DurationBuckets {
+		if v <= le {
+			series.counts[i]++
+		}
+	}
+}
+
+func (m *Metrics) series(name string, help string, kind string, labels []string) *metricSeries {
+	if m.families == nil {
+		m.families = map[string]*metricFamily{}
+	}
+
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{
+			help:    help,
+			kind:    kind,
+			buckets: DurationBuckets,
+			series:  map[string]*metricSeries{},
+		}
+		m.families[name] = family
+	}
+
+	key := joinLabels("", labels...)
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{
+			labels: key,
+			counts: make([]uint64, len(family.buckets)),
+		}
+		family.series[key] = series
+	}
+
+	return series
+}
+
+// joinLabels appends name="value" pairs to an already rendered label list
+func joinLabels(rendered string, labels ...string) string {
+	parts := []string{}
+	if len(rendered) > 0 {
+		parts = append(parts, rendered)
+	}
+
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func wrapLabels(rendered string) string {
+	if len(rendered) == 0 {
+		return ""
+	}
+
+	return "{" + rendered + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,81 @@
+package mon
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestMetricsCounters(t *testing.T) {
+	metrics := Metrics{}
+
+	metrics.CountAction("restart", "health", "/test_cont_abc", nil)
+	metrics.CountAction("restart", "health", "/test_cont_abc", nil)
+	metrics.CountAction("remove", "cleanup", "/test_cont_def", errors.New("test failure"))
+	metrics.CountRetries("Inspect", 3)
+	metrics.CountRetries("Inspect", 0)
+	metrics.CountGC("image", 1024, nil)
+	metrics.CountGC("image", 0, errors.New("test failure"))
+
+	out := metrics.String()
+
+	assert.Contains(t, out, "# TYPE mon_actions_total counter\n")
+	assert.Contains(t, out, `mon_actions_total{action="restart",check="health",container="test_cont_abc",result="ok"} 2`)
+	assert.Contains(t, out, `mon_actions_total{action="remove",check="cleanup",container="test_cont_def",result="error"} 1`)
+	assert.Contains(t, out, `mon_docker_call_retries_total{method="Inspect"} 3`)
+	assert.Contains(t, out, `mon_gc_removed_total{kind="image",result="ok"} 1`)
+	assert.Contains(t, out, `mon_gc_removed_total{kind="image",result="error"} 1`)
+	assert.Contains(t, out, `mon_gc_reclaimed_bytes_total{kind="image"} 1024`)
+}
+
+func TestMetricsHistograms(t *testing.T) {
+	metrics := Metrics{}
+
+	metrics.ObservePoll(20 * time.Millisecond)
+	metrics.ObservePoll(2 * time.Second)
+	metrics.ObserveDockerCall("Restart", time.Second, errors.New("test failure"))
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	assert.Equal(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"), true)
+	assert.Contains(t, out, "# TYPE mon_poll_duration_seconds histogram\n")
+	assert.Contains(t, out, `mon_poll_duration_seconds_bucket{le="0.01"} 0`)
+	assert.Contains(t, out, `mon_poll_duration_seconds_bucket{le="0.025"} 1`)
+	assert.Contains(t, out, `mon_poll_duration_seconds_bucket{le="+Inf"} 2`)
+	assert.Contains(t, out, "mon_poll_duration_seconds_count 2\n")
+	assert.Contains(t, out, `mon_docker_call_duration_seconds_bucket{method="Restart",le="1"} 1`)
+	assert.Contains(t, out, `mon_docker_call_errors_total{method="Restart"} 1`)
+}
+
+func TestMetricsWithHost(t *testing.T) {
+	metrics := &Metrics{}
+
+	metrics.WithHost("a").CountAction("restart", "health", "/test_cont_abc", nil)
+	metrics.WithHost("b").CountAction("restart", "health", "/test_cont_abc", nil)
+	metrics.WithHost("b").ObservePoll(20 * time.Millisecond)
+
+	// every host's series are served together
+	out := metrics.String()
+
+	assert.Contains(t, out, `mon_actions_total{host="a",action="restart",check="health",container="test_cont_abc",result="ok"} 1`)
+	assert.Contains(t, out, `mon_actions_total{host="b",action="restart",check="health",container="test_cont_abc",result="ok"} 1`)
+	assert.Contains(t, out, `mon_poll_duration_seconds_bucket{host="b",le="0.025"} 1`)
+	assert.Equal(t, metrics.WithHost("a").String(), out)
+}
+
+func TestMetricsNil(t *testing.T) {
+	var metrics *Metrics
+
+	// a monitor without metrics configured uses a nil *Metrics, which must be safe to record into
+	metrics.CountAction("restart", "health", "/test_cont_abc", nil)
+	metrics.ObservePoll(time.Second)
+	metrics.WithHost("a").ObservePoll(time.Second)
+
+	assert.Equal(t, metrics.String(), "")
+}
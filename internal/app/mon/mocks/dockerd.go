@@ -5,9 +5,12 @@
 package mock_mon
 
 import (
+	context "context"
 	types "github.com/docker/docker/api/types"
+	events "github.com/docker/docker/api/types/events"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockDockerAPI is a mock of DockerAPI interface
@@ -33,60 +36,178 @@ func (m *MockDockerAPI) EXPECT() *MockDockerAPIMockRecorder {
 	return m.recorder
 }
 
+// Events mocks base method
+func (m *MockDockerAPI) Events(arg0 context.Context, arg1 []string, arg2 time.Time) (<-chan events.Message, <-chan error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Events", arg0, arg1, arg2)
+	ret0, _ := ret[0].(<-chan events.Message)
+	ret1, _ := ret[1].(<-chan error)
+	return ret0, ret1
+}
+
+// Events indicates an expected call of Events
+func (mr *MockDockerAPIMockRecorder) Events(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Events", reflect.TypeOf((*MockDockerAPI)(nil).Events), arg0, arg1, arg2)
+}
+
+// ExecuteHealthQuery mocks base method
+func (m *MockDockerAPI) ExecuteHealthQuery(arg0 context.Context, arg1 []string, arg2 string) ([]types.Container, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExecuteHealthQuery", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]types.Container)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExecuteHealthQuery indicates an expected call of ExecuteHealthQuery
+func (mr *MockDockerAPIMockRecorder) ExecuteHealthQuery(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecuteHealthQuery", reflect.TypeOf((*MockDockerAPI)(nil).ExecuteHealthQuery), arg0, arg1, arg2)
+}
+
 // ExecuteListQuery mocks base method
-func (m *MockDockerAPI) ExecuteListQuery(arg0 []string) ([]types.Container, error) {
+func (m *MockDockerAPI) ExecuteListQuery(arg0 context.Context, arg1 []string) ([]types.Container, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ExecuteListQuery", arg0)
+	ret := m.ctrl.Call(m, "ExecuteListQuery", arg0, arg1)
 	ret0, _ := ret[0].([]types.Container)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ExecuteListQuery indicates an expected call of ExecuteListQuery
-func (mr *MockDockerAPIMockRecorder) ExecuteListQuery(arg0 interface{}) *gomock.Call {
+func (mr *MockDockerAPIMockRecorder) ExecuteListQuery(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecuteListQuery", reflect.TypeOf((*MockDockerAPI)(nil).ExecuteListQuery), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecuteListQuery", reflect.TypeOf((*MockDockerAPI)(nil).ExecuteListQuery), arg0, arg1)
 }
 
 // Inspect mocks base method
-func (m *MockDockerAPI) Inspect(arg0 types.Container) (types.ContainerJSON, error) {
+func (m *MockDockerAPI) Inspect(arg0 context.Context, arg1 types.Container) (types.ContainerJSON, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Inspect", arg0)
+	ret := m.ctrl.Call(m, "Inspect", arg0, arg1)
 	ret0, _ := ret[0].(types.ContainerJSON)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Inspect indicates an expected call of Inspect
-func (mr *MockDockerAPIMockRecorder) Inspect(arg0 interface{}) *gomock.Call {
+func (mr *MockDockerAPIMockRecorder) Inspect(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Inspect", reflect.TypeOf((*MockDockerAPI)(nil).Inspect), arg0, arg1)
+}
+
+// ListImages mocks base method
+func (m *MockDockerAPI) ListImages(arg0 context.Context) ([]types.ImageSummary, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListImages", arg0)
+	ret0, _ := ret[0].([]types.ImageSummary)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListImages indicates an expected call of ListImages
+func (mr *MockDockerAPIMockRecorder) ListImages(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListImages", reflect.TypeOf((*MockDockerAPI)(nil).ListImages), arg0)
+}
+
+// ListNetworks mocks base method
+func (m *MockDockerAPI) ListNetworks(arg0 context.Context, arg1 []string) ([]types.NetworkResource, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListNetworks", arg0, arg1)
+	ret0, _ := ret[0].([]types.NetworkResource)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListNetworks indicates an expected call of ListNetworks
+func (mr *MockDockerAPIMockRecorder) ListNetworks(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Inspect", reflect.TypeOf((*MockDockerAPI)(nil).Inspect), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListNetworks", reflect.TypeOf((*MockDockerAPI)(nil).ListNetworks), arg0, arg1)
+}
+
+// ListVolumes mocks base method
+func (m *MockDockerAPI) ListVolumes(arg0 context.Context, arg1 []string) ([]*types.Volume, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListVolumes", arg0, arg1)
+	ret0, _ := ret[0].([]*types.Volume)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListVolumes indicates an expected call of ListVolumes
+func (mr *MockDockerAPIMockRecorder) ListVolumes(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListVolumes", reflect.TypeOf((*MockDockerAPI)(nil).ListVolumes), arg0, arg1)
 }
 
 // Remove mocks base method
-func (m *MockDockerAPI) Remove(arg0 types.Container) error {
+func (m *MockDockerAPI) Remove(arg0 context.Context, arg1 types.Container, arg2 types.ContainerRemoveOptions) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Remove", arg0)
+	ret := m.ctrl.Call(m, "Remove", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Remove indicates an expected call of Remove
-func (mr *MockDockerAPIMockRecorder) Remove(arg0 interface{}) *gomock.Call {
+func (mr *MockDockerAPIMockRecorder) Remove(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*MockDockerAPI)(nil).Remove), arg0, arg1, arg2)
+}
+
+// RemoveImage mocks base method
+func (m *MockDockerAPI) RemoveImage(arg0 context.Context, arg1 string) ([]types.ImageDelete, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveImage", arg0, arg1)
+	ret0, _ := ret[0].([]types.ImageDelete)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveImage indicates an expected call of RemoveImage
+func (mr *MockDockerAPIMockRecorder) RemoveImage(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveImage", reflect.TypeOf((*MockDockerAPI)(nil).RemoveImage), arg0, arg1)
+}
+
+// RemoveNetwork mocks base method
+func (m *MockDockerAPI) RemoveNetwork(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveNetwork", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveNetwork indicates an expected call of RemoveNetwork
+func (mr *MockDockerAPIMockRecorder) RemoveNetwork(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveNetwork", reflect.TypeOf((*MockDockerAPI)(nil).RemoveNetwork), arg0, arg1)
+}
+
+// RemoveVolume mocks base method
+func (m *MockDockerAPI) RemoveVolume(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveVolume", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveVolume indicates an expected call of RemoveVolume
+func (mr *MockDockerAPIMockRecorder) RemoveVolume(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*MockDockerAPI)(nil).Remove), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveVolume", reflect.TypeOf((*MockDockerAPI)(nil).RemoveVolume), arg0, arg1)
 }
 
 // Restart mocks base method
-func (m *MockDockerAPI) Restart(arg0 int64, arg1 types.Container) error {
+func (m *MockDockerAPI) Restart(arg0 context.Context, arg1 int64, arg2 types.Container) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Restart", arg0, arg1)
+	ret := m.ctrl.Call(m, "Restart", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Restart indicates an expected call of Restart
-func (mr *MockDockerAPIMockRecorder) Restart(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockDockerAPIMockRecorder) Restart(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restart", reflect.TypeOf((*MockDockerAPI)(nil).Restart), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restart", reflect.TypeOf((*MockDockerAPI)(nil).Restart), arg0, arg1, arg2)
 }
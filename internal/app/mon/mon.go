@@ -1,11 +1,14 @@
 package mon
 
 import (
-	"log"
+	"context"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 )
 
 // ObserveLabel is how we detect containers we want to monitor
@@ -17,15 +20,40 @@ const CheckHealthLabel string = "mon.checks.health=1"
 // CheckCleanupLabel is how we detect containers we want to remove if they exit cleanly
 const CheckCleanupLabel string = "mon.checks.cleanup=1"
 
-// CleanupExitCodeLabelKey is the label key in which the expected exit code can be overriden
+// CleanupExitCodeLabelKey is the label key in which the expected exit codes can be overriden, as a list like "0,3",
+// "0-2", "any" or "!137" (see ParseExitCodes)
 const CleanupExitCodeLabelKey string = "mon.checks.cleanup.code"
 
+// CleanupAfterLabelKey is the label key in which the time a container has to have exited for, before cleanup, can be
+// overriden - in ms, or with a unit (e.g. "10m")
+const CleanupAfterLabelKey string = "mon.checks.cleanup.after"
+
+// CleanupCreatedAfterLabelKey is the label key in which the time a container has to be stuck in the created state for,
+// before it's cleaned up, can be overriden - in ms, or with a unit (e.g. "1h"). 0 leaves it alone
+const CleanupCreatedAfterLabelKey string = "mon.checks.cleanup.created.after"
+
+// CleanupDeadAfterLabelKey is the label key in which the time a container has to be dead for, before it's forcibly
+// cleaned up, can be overriden - in ms, or with a unit (e.g. "1h"). 0 leaves it alone
+const CleanupDeadAfterLabelKey string = "mon.checks.cleanup.dead.after"
+
+// CleanupVolumesLabelKey is the label key which, set to 1, has cleanup remove the container's anonymous volumes too
+const CleanupVolumesLabelKey string = "mon.checks.cleanup.volumes"
+
+// CleanupLinksLabelKey is the label key which, set to 1, has cleanup remove the links other containers have to it too
+const CleanupLinksLabelKey string = "mon.checks.cleanup.links"
+
 // HealthRestartLabelKey is the label key in which the expected restart timeout can be overriden
 const HealthRestartLabelKey string = "mon.checks.health.timeout"
 
 // DefaultCleanupExitCode is the default exit code we expect for cleanup
 const DefaultCleanupExitCode int = 0
 
+// defaultCleanupExitCodes matches just DefaultCleanupExitCode
+var defaultCleanupExitCodes = &ExitCodeMatcher{
+	raw:     strconv.Itoa(DefaultCleanupExitCode),
+	include: []exitCodeRange{{from: DefaultCleanupExitCode, to: DefaultCleanupExitCode}},
+}
+
 // DefaultRestartTimeoutMs is the default timeout for restarting
 const DefaultRestartTimeoutMs int64 = 10 * 1000
 
@@ -35,122 +63,699 @@ const RunningState string = "running"
 // ExitedState is the literal "exited"
 const ExitedState string = "exited"
 
+// CreatedState is the literal "created", for a container that was never started
+const CreatedState string = "created"
+
+// DeadState is the literal "dead", for a container the daemon failed to remove
+const DeadState string = "dead"
+
 // Monitor is the core application controller, to monitor and act on containers
 type Monitor struct {
-	ContainerPrefix string
-	Dockerd         DockerAPI
-	Quiet           bool
+	// Host names the docker daemon being monitored, in container statuses and notifications
+	Host string
+	// Selector chooses the containers to observe, nil observes every container labelled (or matched by a rule) for it
+	Selector  *Selector
+	Dockerd   DockerAPI
+	Log       *Logger
+	Metrics   *Metrics
+	Notifiers []Notifier
+	// Rules apply checks to containers that aren't labelled for them
+	Rules []Rule
+	// Concurrency is how many containers are checked at once, zero uses the default
+	Concurrency int
+	// CleanupAfterMs is how long a container has to have exited for before it's cleaned up, unless its label says
+	// otherwise - zero cleans it up right away
+	CleanupAfterMs int64
+	// CleanupCreatedAfterMs and CleanupDeadAfterMs are how long a container has to be stuck in the created or dead
+	// state before it's cleaned up, unless its labels say otherwise - zero leaves them alone
+	CleanupCreatedAfterMs int64
+	CleanupDeadAfterMs    int64
+	// ImageGC removes unused images after each poll's cleanup, nil leaves images alone
+	ImageGC *ImageGC
+	// ResourceGC removes unused volumes and networks after each poll's cleanup, nil leaves them alone
+	ResourceGC *ResourceGC
+	// pollMu keeps polls from overlapping, settingsMu keeps settings from changing under a poll (or event)
+	pollMu     sync.Mutex
+	settingsMu sync.RWMutex
+	// mu guards the state below
+	mu       sync.Mutex
+	removed  map[string]bool
+	restarts map[string]*restartHistory
+	inFlight map[string]bool
+	statuses map[string]*ContainerStatus
+	lastPoll time.Time
+	clock    func() time.Time
+	// unusedSince is when each volume ResourceGC could collect was first seen unused
+	unusedSince map[string]time.Time
 }
 
-func (m *Monitor) handleContainerHealth() {
-	conts, err := m.Dockerd.ExecuteListQuery([]string{
-		ObserveLabel,
-		CheckHealthLabel,
-	})
+// filterList is the label filters that can be pushed down to the daemon, when listing containers with the given check
+func (m *Monitor) filterList(checkLabel string) []string {
+	filterList := m.Selector.Filters()
+
+	// the rules can apply to containers without any of our labels, so then we have to filter for ourselves
+	if len(m.Rules) == 0 {
+		filterList = append([]string{ObserveLabel, checkLabel}, filterList...)
+	}
 
+	return filterList
+}
+
+// listContainers finds the selected containers with the given check, by their labels or the rules
+func (m *Monitor) listContainers(ctx context.Context, checkLabel string) ([]types.Container, error) {
+	all, err := m.Dockerd.ExecuteListQuery(ctx, m.filterList(checkLabel))
 	if err != nil {
-		log.Printf("ExecuteListQuery failed: %v\n", err)
-		return
+		return nil, err
 	}
 
-	for _, cont := range conts {
-		//if we have a prefix value, and cont doesn't satisfy it, move along
-		if len(m.ContainerPrefix) > 0 && !namesContainPrefix(cont.Names, m.ContainerPrefix) {
+	var conts []types.Container
+	for _, cont := range all {
+		// the selector sees the container's own labels, not those the rules give it
+		if !m.Selector.Matches(cont) {
 			continue
 		}
 
-		expectedRestartTimeoutMs := DefaultRestartTimeoutMs
-		if restartMs, ok := cont.Labels[HealthRestartLabelKey]; ok {
-			if i, err := strconv.Atoi(restartMs); err == nil {
-				expectedRestartTimeoutMs = int64(i)
-			}
+		cont = applyRules(m.Rules, cont)
+		if labelsContain(cont.Labels, ObserveLabel) && labelsContain(cont.Labels, checkLabel) {
+			conts = append(conts, cont)
 		}
+	}
 
-		// if it's running, we might need to restart it - we guard the "expensive" inspect call this way
-		if cont.State == RunningState {
-			if !m.Quiet {
-				log.Printf("Checking container health: %v (%v)\n", cont.ID, cont.Names[0])
-			}
+	return conts, nil
+}
 
-			inspect, err := m.Dockerd.Inspect(cont)
-			if err != nil {
-				log.Printf("Inspect failed: %v\n", err)
-				continue
-			}
+func (m *Monitor) handleContainerHealth(ctx context.Context) ([]checkResult, error) {
+	conts, err := m.listContainers(ctx, CheckHealthLabel)
 
-			if inspect.State != nil && inspect.State.Health != nil && inspect.State.Health.Status == types.Unhealthy {
-				if !m.Quiet {
-					log.Printf("Found unhealthy running container: %v (%v)\n", cont.ID, cont.Names[0])
-				}
-				if err := m.Dockerd.Restart(expectedRestartTimeoutMs, cont); err != nil {
-					log.Printf("Failed to restart unhealthy container %v (%v): %v\n", cont.ID, cont.Names[0], err)
-				} else {
-					log.Printf("Container restarted: %v (%v)\n", cont.ID, cont.Names[0])
-				}
-			}
+	if err != nil {
+		m.Log.With(Fields{"check": HealthCheck}).WithError(err).Error("ExecuteListQuery failed")
+		return nil, err
+	}
+
+	check := m.checkContainerHealth
+	if unhealthy := m.listUnhealthy(ctx); unhealthy != nil {
+		check = func(ctx context.Context, cont types.Container) checkResult {
+			return m.checkHealth(ctx, cont, unhealthy)
 		}
 	}
+
+	results := m.forEachContainer(ctx, conts, m.recorded(HealthCheck, check))
+
+	seen := map[string]bool{}
+	for _, cont := range conts {
+		seen[cont.ID] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// containers that are gone (or no longer checked) don't need their history
+	for id := range m.restarts {
+		if !seen[id] {
+			delete(m.restarts, id)
+		}
+	}
+
+	return results, nil
 }
 
-func (m *Monitor) handleContainerCleanup() {
-	conts, err := m.Dockerd.ExecuteListQuery([]string{
-		ObserveLabel,
-		CheckCleanupLabel,
-	})
+// listUnhealthy finds the IDs of unhealthy containers if the daemon can list them, nil if every running container has
+// to be inspected for its health instead
+func (m *Monitor) listUnhealthy(ctx context.Context) map[string]bool {
+	if !m.features(ctx).HealthFilter {
+		return nil
+	}
 
+	conts, err := m.Dockerd.ExecuteHealthQuery(ctx, m.filterList(CheckHealthLabel), types.Unhealthy)
 	if err != nil {
-		log.Printf("ExecuteListQuery failed: %v\n", err)
-		return
+		m.Log.With(Fields{"check": HealthCheck}).WithError(err).Warn("ExecuteHealthQuery failed, inspecting every container")
+		return nil
 	}
 
+	unhealthy := map[string]bool{}
 	for _, cont := range conts {
-		//if we have a prefix value, and cont doesn't satisfy it, move along
-		if len(m.ContainerPrefix) > 0 && !namesContainPrefix(cont.Names, m.ContainerPrefix) {
-			continue
+		unhealthy[cont.ID] = true
+	}
+
+	return unhealthy
+}
+
+// features finds the parts of the docker API the daemon supports, none of them if its version isn't known
+func (m *Monitor) features(ctx context.Context) APIFeatures {
+	versioner, ok := m.Dockerd.(APIVersioner)
+	if !ok {
+		return APIFeatures{}
+	}
+
+	version, err := versioner.APIVersion(ctx)
+	if err != nil {
+		m.Log.WithError(err).Debug("Docker API version unknown")
+		return APIFeatures{}
+	}
+
+	return FeaturesFor(version)
+}
+
+func (m *Monitor) checkContainerHealth(ctx context.Context, cont types.Container) checkResult {
+	return m.checkHealth(ctx, cont, nil)
+}
+
+// checkHealth restarts the container if it's unhealthy, skipping the inspect if it's not in a non-nil unhealthy set
+func (m *Monitor) checkHealth(ctx context.Context, cont types.Container, unhealthy map[string]bool) checkResult {
+	logger := m.Log.WithContainer(cont.ID, cont.Names[0]).With(Fields{"check": HealthCheck})
+
+	// unlike cleanup's, a typo here still leaves the container restarted - just on the defaults, so it's only a warning
+	expectedRestartTimeoutMs, err := labelMs(cont.Labels, HealthRestartLabelKey, DefaultRestartTimeoutMs)
+	if err != nil {
+		logger.WithError(err).Warn("Invalid restart timeout, using the default")
+		expectedRestartTimeoutMs = DefaultRestartTimeoutMs
+	}
+
+	maxRestarts := DefaultMaxRestarts
+	if max, ok := cont.Labels[HealthMaxRestartsLabelKey]; ok {
+		if i, err := strconv.Atoi(max); err == nil && i >= 0 {
+			maxRestarts = i
+		} else {
+			logger.With(Fields{"max_restarts": max}).Warn("Invalid max restarts, using the default")
 		}
+	}
 
-		expectedExitCode := DefaultCleanupExitCode
-		if errCode, ok := cont.Labels[CleanupExitCodeLabelKey]; ok {
-			if i, err := strconv.Atoi(errCode); err == nil {
-				expectedExitCode = i
-			}
+	restartWindowMs, err := labelMs(cont.Labels, HealthRestartWindowLabelKey, DefaultRestartWindowMs)
+	if err != nil {
+		logger.WithError(err).Warn("Invalid restart window, using the default")
+		restartWindowMs = DefaultRestartWindowMs
+	}
+
+	if !m.acquire(cont.ID) {
+		logger.Debug("Container already being acted on, skipping")
+		return checkResult{checked: true, busy: true}
+	}
+	defer m.release(cont.ID)
+
+	// if it's running, we might need to restart it - we guard the "expensive" inspect call this way
+	if cont.State == RunningState {
+		// one we gave up on is still inspected though, to notice when it recovers
+		if history, ok := m.existingRestartHistory(cont.ID); unhealthy != nil && !unhealthy[cont.ID] && (!ok || !history.givenUp) {
+			logger.Debug("Container isn't unhealthy, skipping inspect")
+			return checkResult{checked: true}
 		}
 
-		// if it's exited, it's likely we'll need to clean it - we guard the "expensive" inspect call this way
-		if cont.State == ExitedState {
-			if !m.Quiet {
-				log.Printf("Checking container cleanliness: %v (%v)\n", cont.ID, cont.Names[0])
+		logger.Debug("Checking container health")
+
+		inspect, err := m.Dockerd.Inspect(ctx, cont)
+		if err != nil {
+			logger.WithError(err).Error("Inspect failed")
+			return checkResult{checked: true, err: err}
+		}
+
+		// nothing else touches a container's history while we hold it
+		if history, ok := m.existingRestartHistory(cont.ID); ok && history.givenUp && inspect.State != nil && inspect.State.Health != nil && inspect.State.Health.Status == types.Healthy {
+			// it recovered without us, but restarts still within the window count against it if it fails again
+			logger.Info("Container recovered, resuming restarts")
+			history.givenUp = false
+		}
+
+		if inspect.State != nil && inspect.State.Health != nil && inspect.State.Health.Status == types.Unhealthy {
+			logger.Debug("Found unhealthy running container")
+
+			now := m.now()
+			history := m.restartHistory(cont.ID)
+			history.prune(now, time.Duration(restartWindowMs)*time.Millisecond)
+
+			if history.givenUp {
+				logger.Debug("Not restarting container, already gave up")
+				return checkResult{checked: true}
 			}
 
-			inspect, err := m.Dockerd.Inspect(cont)
-			if err != nil {
-				log.Printf("Inspect failed: %v\n", err)
-				continue
+			if len(history.restarts) >= maxRestarts {
+				history.givenUp = true
+				logger.With(Fields{
+					"action":    GiveUpAction,
+					"restarts":  len(history.restarts),
+					"window_ms": restartWindowMs,
+				}).Warn("Giving up on crash-looping container")
+				m.notify(GiveUpAction, HealthCheck, cont, nil)
+				return checkResult{checked: true, action: GiveUpAction}
 			}
 
-			// if it's got the expected error code, we clean it up
-			if inspect.State.ExitCode == expectedExitCode {
-				if !m.Quiet {
-					log.Printf("Found container to cleanup: %v (%v)\n", cont.ID, cont.Names[0])
-				}
-				if err := m.Dockerd.Remove(cont); err != nil {
-					log.Printf("Failed to remove container %v (%v): %v\n", cont.ID, cont.Names[0], err)
-				} else {
-					log.Printf("Container cleaned: %v (%v)\n", cont.ID, cont.Names[0])
-				}
+			if wait := history.backoff(now); wait > 0 {
+				logger.With(Fields{"backoff": wait}).Debug("Backing off restart")
+				return checkResult{checked: true}
+			}
+
+			// failed attempts count too, so a broken daemon doesn't get hammered either
+			history.record(now)
+
+			err := m.Dockerd.Restart(ctx, expectedRestartTimeoutMs, cont)
+			m.actionMetrics().CountAction(RestartAction, HealthCheck, cont.Names[0], err)
+			m.notify(RestartAction, HealthCheck, cont, err)
+
+			logger = logger.With(Fields{"action": RestartAction})
+			switch {
+			case err != nil:
+				logger.WithError(err).Error("Failed to restart unhealthy container")
+			case m.dryRun():
+				logger.Info("Container would be restarted")
+			default:
+				logger.Info("Container restarted")
 			}
+
+			return checkResult{checked: true, action: RestartAction, planned: m.dryRun(), err: err}
+		}
+	}
+
+	return checkResult{checked: true}
+}
+
+func (m *Monitor) handleContainerCleanup(ctx context.Context) ([]checkResult, error) {
+	conts, err := m.listContainers(ctx, CheckCleanupLabel)
+
+	if err != nil {
+		m.Log.With(Fields{"check": CleanupCheck}).WithError(err).Error("ExecuteListQuery failed")
+		return nil, err
+	}
+
+	return m.forEachContainer(ctx, conts, m.recorded(CleanupCheck, m.checkContainerCleanup)), nil
+}
+
+func (m *Monitor) checkContainerCleanup(ctx context.Context, cont types.Container) checkResult {
+	exitCodes := defaultCleanupExitCodes
+	if raw, ok := cont.Labels[CleanupExitCodeLabelKey]; ok {
+		parsed, err := ParseExitCodes(raw)
+		if err != nil {
+			// guessing could remove a container someone wanted kept
+			m.Log.WithContainer(cont.ID, cont.Names[0]).With(Fields{"check": CleanupCheck}).WithError(err).Error("Invalid cleanup exit codes, skipping container")
+			return checkResult{checked: true, err: err}
+		}
+
+		exitCodes = parsed
+	}
+
+	// each state waits on its own time before cleanup
+	afterKey, afterMs := CleanupAfterLabelKey, m.CleanupAfterMs
+	switch cont.State {
+	case CreatedState:
+		afterKey, afterMs = CleanupCreatedAfterLabelKey, m.CleanupCreatedAfterMs
+	case DeadState:
+		afterKey, afterMs = CleanupDeadAfterLabelKey, m.CleanupDeadAfterMs
+	}
+
+	afterMs, err := labelMs(cont.Labels, afterKey, afterMs)
+	if err != nil {
+		// falling back to the default could remove a container before its grace period is up
+		m.Log.WithContainer(cont.ID, cont.Names[0]).With(Fields{"check": CleanupCheck}).WithError(err).Error("Invalid cleanup time, skipping container")
+		return checkResult{checked: true, err: err}
+	}
+
+	logger := m.Log.WithContainer(cont.ID, cont.Names[0]).With(Fields{"check": CleanupCheck})
+
+	if !m.acquire(cont.ID) {
+		logger.Debug("Container already being acted on, skipping")
+		return checkResult{checked: true, busy: true}
+	}
+	defer m.release(cont.ID)
+
+	switch cont.State {
+	case CreatedState, DeadState:
+		return m.checkStuckContainer(ctx, cont, afterMs, logger)
+	case ExitedState:
+		// if it's exited, it's likely we'll need to clean it - we guard the "expensive" inspect call this way
+		logger.Debug("Checking container cleanliness")
+
+		inspect, err := m.Dockerd.Inspect(ctx, cont)
+		if err != nil {
+			logger.WithError(err).Error("Inspect failed")
+			return checkResult{checked: true, err: err}
+		}
+
+		// a die (or stop) event can arrive after its restart policy has already started it again
+		if inspect.State == nil || inspect.State.Status != ExitedState {
+			logger.Debug("Container no longer exited, not cleaning it up")
+			return checkResult{checked: true}
+		}
+
+		// if it's got an expected exit code, we clean it up
+		if exitCodes.Matches(inspect.State.ExitCode) {
+			// leave it around for a while, to be looked at
+			if remaining := m.cleanupRemaining(inspect, afterMs); remaining > 0 {
+				logger.With(Fields{"remaining": remaining}).Debug("Container exited recently, waiting to cleanup")
+				return checkResult{checked: true}
+			}
+
+			logger.Debug("Found container to cleanup")
+			return m.removeContainer(ctx, cont, inspect, logger)
+		}
+	}
+
+	return checkResult{checked: true}
+}
+
+// checkStuckContainer cleans up a container stuck in the created or dead state, once it's been stuck for afterMs
+func (m *Monitor) checkStuckContainer(ctx context.Context, cont types.Container, afterMs int64, logger *Logger) checkResult {
+	// cleaning up these states is opt-in
+	if afterMs <= 0 {
+		return checkResult{checked: true}
+	}
+
+	logger = logger.With(Fields{"state": cont.State})
+	logger.Debug("Checking stuck container")
+
+	inspect, err := m.Dockerd.Inspect(ctx, cont)
+	if err != nil {
+		logger.WithError(err).Error("Inspect failed")
+		return checkResult{checked: true, err: err}
+	}
+
+	// unlike an exited container, one we can't tell the age of is left alone - it may be about to start
+	since, ok := stuckSince(inspect)
+	if !ok {
+		logger.Debug("Container age unknown, not cleaning it up")
+		return checkResult{checked: true}
+	}
+
+	if remaining := time.Duration(afterMs)*time.Millisecond - m.now().Sub(since); remaining > 0 {
+		logger.With(Fields{"remaining": remaining}).Debug("Container not stuck for long enough, waiting to cleanup")
+		return checkResult{checked: true}
+	}
+
+	logger.Debug("Found stuck container to cleanup")
+	return m.removeContainer(ctx, cont, inspect, logger)
+}
+
+// removeContainer removes a container found by the cleanup check, with what its labels say should go along with it. A
+// dead container is removed forcibly, as the daemon already failed to remove it once
+func (m *Monitor) removeContainer(ctx context.Context, cont types.Container, inspect types.ContainerJSON, logger *Logger) checkResult {
+	options := types.ContainerRemoveOptions{
+		RemoveVolumes: cont.Labels[CleanupVolumesLabelKey] == "1",
+		RemoveLinks:   cont.Labels[CleanupLinksLabelKey] == "1",
+		Force:         cont.State == DeadState,
+	}
+
+	err := m.Dockerd.Remove(ctx, cont, options)
+	m.actionMetrics().CountAction(RemoveAction, CleanupCheck, cont.Names[0], err)
+	m.notify(RemoveAction, CleanupCheck, cont, err)
+
+	logger = logger.With(Fields{"action": RemoveAction, "state": cont.State})
+	if options.RemoveVolumes {
+		logger = logger.With(Fields{"volumes": anonymousVolumes(inspect)})
+	}
+	if options.RemoveLinks {
+		logger = logger.With(Fields{"links": linkNames(cont)})
+	}
+
+	switch {
+	case err != nil:
+		logger.WithError(err).Error("Failed to remove container")
+	case m.dryRun():
+		// it's still there, so its later events still need handling
+		logger.Info("Container would be cleaned")
+	default:
+		m.markRemoved(cont.ID)
+		logger.Info("Container cleaned")
+	}
+
+	return checkResult{checked: true, action: RemoveAction, planned: m.dryRun(), err: err}
+}
+
+// stuckSince is when a created or dead container got stuck, by when it died or else was created
+func stuckSince(inspect types.ContainerJSON) (time.Time, bool) {
+	times := []string{inspect.Created}
+	if inspect.State != nil {
+		times = append([]string{inspect.State.FinishedAt}, times...)
+	}
+
+	for _, raw := range times {
+		if t, err := time.Parse(time.RFC3339Nano, raw); err == nil && !t.IsZero() {
+			return t, true
 		}
 	}
+
+	return time.Time{}, false
+}
+
+// cleanupRemaining is how much longer an exited container has to wait to be cleaned up, by when it finished
+func (m *Monitor) cleanupRemaining(inspect types.ContainerJSON, cleanupAfterMs int64) time.Duration {
+	if cleanupAfterMs <= 0 {
+		return 0
+	}
+
+	// a container without a (valid) finish time has been around long enough, as far as we can tell
+	finished, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+	if err != nil || finished.IsZero() {
+		return 0
+	}
+
+	return time.Duration(cleanupAfterMs)*time.Millisecond - m.now().Sub(finished)
 }
 
 // Poll checks the dockerd system and executes operations as needed
-func (m *Monitor) Poll(t time.Time) {
-	if !m.Quiet {
-		log.Printf("CheckStart for %v\n", t)
-	}
-	m.handleContainerHealth()
-	m.handleContainerCleanup()
-	if !m.Quiet {
-		log.Printf("CheckEnd for %v\n", t)
+func (m *Monitor) Poll(ctx context.Context, t time.Time) {
+	m.pollMu.Lock()
+	defer m.pollMu.Unlock()
+	m.settingsMu.RLock()
+	defer m.settingsMu.RUnlock()
+
+	start := time.Now()
+	defer func() {
+		m.Metrics.ObservePoll(time.Since(start))
+	}()
+
+	logger := m.Log.With(Fields{"poll": t})
+
+	logger.Debug("CheckStart")
+
+	results := pollResults{}
+	seen := map[string]bool{}
+	complete := true
+
+	for _, handle := range []func(context.Context) ([]checkResult, error){m.handleContainerHealth, m.handleContainerCleanup} {
+		checked, err := handle(ctx)
+		if err != nil {
+			results.failed++
+			complete = false
+		}
+		for _, res := range checked {
+			results.add(res)
+			if res.checked {
+				seen[res.id] = true
+			}
+		}
+	}
+
+	// once cleanup's done, the images, volumes and networks it left unused can go too
+	if (m.ImageGC != nil || m.ResourceGC != nil) && ctx.Err() == nil {
+		for _, res := range m.collectGarbage(ctx) {
+			results.add(res)
+		}
+	}
+
+	// only a poll that saw everything can tell us what's no longer observed
+	if complete && ctx.Err() == nil {
+		m.pruneStatuses(seen)
+		m.pruneRemoved(seen)
+		m.polled(m.now())
+	}
+
+	if summarizer, ok := m.Dockerd.(PollSummarizer); ok {
+		summarizer.SummarizePoll(t)
+	}
+
+	logger = logger.With(results.fields())
+	if results.eventful() {
+		logger.Info("CheckEnd")
+	} else {
+		logger.Debug("CheckEnd")
+	}
+}
+
+// HandleEvent checks a single container as soon as docker reports a relevant change, rather than waiting for the next poll
+func (m *Monitor) HandleEvent(ctx context.Context, msg events.Message) {
+	if msg.Type != events.ContainerEventType {
+		return
+	}
+
+	m.settingsMu.RLock()
+	defer m.settingsMu.RUnlock()
+
+	cont := eventToContainer(msg)
+
+	m.mu.Lock()
+
+	// destroy is the last event we'll see for a container, so whatever we know about it can go
+	if msg.Action == DestroyEvent {
+		delete(m.removed, cont.ID)
+		delete(m.restarts, cont.ID)
+		delete(m.statuses, cont.ID)
+		m.mu.Unlock()
+		return
+	}
+
+	removed := m.removed[cont.ID]
+	m.mu.Unlock()
+
+	// we've removed it ourselves, the trailing events have nothing left to act on
+	if removed || !m.Selector.Matches(cont) {
+		return
+	}
+
+	cont = applyRules(m.Rules, cont)
+	if !labelsContain(cont.Labels, ObserveLabel) {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(msg.Action, HealthStatusEvent):
+		if msg.Action == HealthStatusEvent+": "+types.Unhealthy && labelsContain(cont.Labels, CheckHealthLabel) {
+			cont.State = RunningState
+			m.recorded(HealthCheck, m.checkContainerHealth)(ctx, cont)
+		}
+	case msg.Action == DieEvent, msg.Action == StopEvent:
+		if labelsContain(cont.Labels, CheckCleanupLabel) {
+			cont.State = ExitedState
+			m.recorded(CleanupCheck, m.checkContainerCleanup)(ctx, cont)
+		}
+	}
+}
+
+func (m *Monitor) markRemoved(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.removed == nil {
+		m.removed = map[string]bool{}
+	}
+
+	m.removed[id] = true
+}
+
+// pruneRemoved forgets the containers we removed once they're no longer listed, in case their destroy event was missed
+func (m *Monitor) pruneRemoved(seen map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range m.removed {
+		if !seen[id] {
+			delete(m.removed, id)
+		}
+	}
+}
+
+func (m *Monitor) restartHistory(id string) *restartHistory {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.restarts == nil {
+		m.restarts = map[string]*restartHistory{}
+	}
+
+	history, ok := m.restarts[id]
+	if !ok {
+		history = &restartHistory{}
+		m.restarts[id] = history
+	}
+
+	return history
+}
+
+func (m *Monitor) existingRestartHistory(id string) (*restartHistory, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	history, ok := m.restarts[id]
+	return history, ok
+}
+
+// MonitorSettings are the parts of a Monitor that can be changed while it's running
+type MonitorSettings struct {
+	Selector    *Selector
+	Rules       []Rule
+	Notifiers   []Notifier
+	Concurrency int
+	// CleanupAfterMs is how long a container has to have exited for before it's cleaned up
+	CleanupAfterMs int64
+	// CleanupCreatedAfterMs and CleanupDeadAfterMs are how long a container has to be stuck in those states for
+	CleanupCreatedAfterMs int64
+	CleanupDeadAfterMs    int64
+	// ImageGC removes unused images, nil leaves them alone
+	ImageGC *ImageGC
+	// ResourceGC removes unused volumes and networks, nil leaves them alone
+	ResourceGC *ResourceGC
+}
+
+// Configure swaps in new settings, once any in-flight poll (or event) is done with the old ones
+func (m *Monitor) Configure(settings MonitorSettings) {
+	m.settingsMu.Lock()
+	defer m.settingsMu.Unlock()
+
+	m.Selector = settings.Selector
+	m.Rules = settings.Rules
+	m.Notifiers = settings.Notifiers
+	m.Concurrency = settings.Concurrency
+	m.CleanupAfterMs = settings.CleanupAfterMs
+	m.CleanupCreatedAfterMs = settings.CleanupCreatedAfterMs
+	m.CleanupDeadAfterMs = settings.CleanupDeadAfterMs
+	m.ImageGC = settings.ImageGC
+	m.ResourceGC = settings.ResourceGC
+}
+
+// dryRun is true if restarts, removals and garbage collection only happen in the logs
+func (m *Monitor) dryRun() bool {
+	_, ok := m.Dockerd.(*DryRunDockerAPI)
+	return ok
+}
+
+// actionMetrics are where restarts, removals and garbage collection are counted - nowhere in a dry run, as they
+// don't happen
+func (m *Monitor) actionMetrics() *Metrics {
+	if m.dryRun() {
+		return nil
+	}
+
+	return m.Metrics
+}
+
+// LastPoll is when the last poll that checked every container finished, zero if there hasn't been one
+func (m *Monitor) LastPoll() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastPoll
+}
+
+func (m *Monitor) polled(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastPoll = t
+}
+
+func (m *Monitor) now() time.Time {
+	if m.clock != nil {
+		return m.clock()
+	}
+
+	return time.Now()
+}
+
+// notify tells every notifier about an action, failed or not
+func (m *Monitor) notify(action string, check string, cont types.Container, err error) {
+	n := Notification{
+		Host:          m.Host,
+		Action:        action,
+		Check:         check,
+		ContainerID:   cont.ID,
+		ContainerName: strings.TrimPrefix(cont.Names[0], "/"),
+		State:         cont.State,
+		Time:          m.now(),
+	}
+
+	if err != nil {
+		n.Error = err.Error()
+	}
+
+	for _, notifier := range m.Notifiers {
+		if err := notifier.Notify(n); err != nil {
+			m.Log.WithContainer(cont.ID, cont.Names[0]).With(Fields{"check": check, "action": action}).WithError(err).Warn("Notify failed")
+		}
 	}
 }
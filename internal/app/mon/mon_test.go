@@ -1,15 +1,20 @@
 package mon
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/pkg/testutil/assert"
 	"github.com/golang/mock/gomock"
 )
 
@@ -174,7 +179,7 @@ func TestMonitorHandleCleanupOk(t *testing.T) {
 
 	m.
 		EXPECT().
-		ExecuteListQuery(gomock.Eq([]string{
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
 			ObserveLabel,
 			CheckCleanupLabel,
 		})).
@@ -184,31 +189,346 @@ func TestMonitorHandleCleanupOk(t *testing.T) {
 		}, testContainers), nil)
 	m.
 		EXPECT().
-		Inspect(gomock.Eq(testContainers[0])).
+		Inspect(gomock.Any(), gomock.Eq(testContainers[0])).
 		Times(1).
 		Return(testData[0], nil)
 	m.
 		EXPECT().
-		Inspect(gomock.Eq(testContainers[1])).
+		Inspect(gomock.Any(), gomock.Eq(testContainers[1])).
 		Times(1).
 		Return(testData[1], nil)
 	m.
 		EXPECT().
-		Remove(gomock.Eq(testContainers[0])).
+		Remove(gomock.Any(), gomock.Eq(testContainers[0]), gomock.Eq(types.ContainerRemoveOptions{})).
 		Times(1).
 		Return(nil)
 	m.
 		EXPECT().
-		Remove(gomock.Eq(testContainers[1])).
+		Remove(gomock.Any(), gomock.Eq(testContainers[1]), gomock.Eq(types.ContainerRemoveOptions{})).
 		Times(1).
 		Return(nil)
 
 	monitor := Monitor{
-		ContainerPrefix: testContainerNamePrefix,
-		Dockerd:         m,
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  m,
 	}
 
-	monitor.handleContainerCleanup()
+	monitor.handleContainerCleanup(context.Background())
+}
+
+func TestMonitorCleanupVolumesAndLinks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	cont := types.Container{
+		ID:    "vol",
+		Names: []string{"/" + testContainerNamePrefix + "_vol", "/web/db"},
+		State: ExitedState,
+		Labels: map[string]string{
+			"mon.observe":                "1",
+			"mon.checks.cleanup":         "1",
+			"mon.checks.cleanup.volumes": "1",
+			"mon.checks.cleanup.links":   "1",
+		},
+	}
+	anonymous := strings.Repeat("ab", 32)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Return([]types.Container{cont}, nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(cont)).
+		Times(1).
+		Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    "vol",
+				State: &types.ContainerState{Status: ExitedState},
+			},
+			Mounts: []types.MountPoint{
+				{Type: "volume", Name: anonymous, Destination: "/data"},
+				{Type: "volume", Name: "pgdata", Destination: "/var/lib/postgresql/data"},
+			},
+		}, nil)
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(cont), gomock.Eq(types.ContainerRemoveOptions{RemoveVolumes: true, RemoveLinks: true})).
+		Times(1).
+		Return(nil)
+
+	var out bytes.Buffer
+	monitor := Monitor{
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  m,
+		Log:      NewLogger(&out, InfoLevel, false),
+	}
+
+	monitor.handleContainerCleanup(context.Background())
+
+	// the anonymous volume goes with the container, the named one stays
+	assert.Contains(t, out.String(), anonymous)
+	assert.Equal(t, strings.Contains(out.String(), "pgdata"), false)
+	assert.Contains(t, out.String(), "/web/db")
+}
+
+func TestMonitorCleanupAfter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	finished := now.Add(-2 * time.Minute).Format(time.RFC3339Nano)
+
+	recent := types.Container{
+		ID:     "recent",
+		Names:  []string{"/" + testContainerNamePrefix + "_recent"},
+		State:  ExitedState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "mon.checks.cleanup.after": "5m"},
+	}
+	old := types.Container{
+		ID:     "old",
+		Names:  []string{"/" + testContainerNamePrefix + "_old"},
+		State:  ExitedState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1"},
+	}
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Return([]types.Container{recent, old}, nil)
+	for _, cont := range []types.Container{recent, old} {
+		m.
+			EXPECT().
+			Inspect(gomock.Any(), gomock.Eq(cont)).
+			Times(1).
+			Return(types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{
+					ID:    cont.ID,
+					State: &types.ContainerState{Status: ExitedState, FinishedAt: finished},
+				},
+			}, nil)
+	}
+	// only the container past its own grace period goes
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(old), gomock.Any()).
+		Times(1).
+		Return(nil)
+
+	var out bytes.Buffer
+	monitor := Monitor{
+		Selector:       &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:        m,
+		Log:            NewLogger(&out, DebugLevel, false),
+		CleanupAfterMs: 60 * 1000,
+		clock:          func() time.Time { return now },
+	}
+
+	monitor.handleContainerCleanup(context.Background())
+
+	assert.Contains(t, out.String(), "remaining=3m0s")
+}
+
+func TestMonitorCleanupAfterInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	exited := types.Container{
+		ID:     "exited",
+		Names:  []string{"/" + testContainerNamePrefix + "_exited"},
+		State:  ExitedState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "mon.checks.cleanup.after": "1 hour"},
+	}
+	created := types.Container{
+		ID:     "created",
+		Names:  []string{"/" + testContainerNamePrefix + "_created"},
+		State:  CreatedState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "mon.checks.cleanup.created.after": "soon"},
+	}
+	dead := types.Container{
+		ID:     "dead",
+		Names:  []string{"/" + testContainerNamePrefix + "_dead"},
+		State:  DeadState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "mon.checks.cleanup.dead.after": "soon"},
+	}
+
+	// none of them are inspected, let alone removed on the global defaults
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Return([]types.Container{exited, created, dead}, nil)
+
+	var out bytes.Buffer
+	monitor := Monitor{
+		Selector:              &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:               m,
+		Log:                   NewLogger(&out, DebugLevel, false),
+		CleanupCreatedAfterMs: 1,
+		CleanupDeadAfterMs:    1,
+		clock:                 func() time.Time { return now },
+	}
+
+	results, err := monitor.handleContainerCleanup(context.Background())
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(results), 3)
+	for _, res := range results {
+		assert.NotNil(t, res.err)
+	}
+	assert.Equal(t, strings.Count(out.String(), "Invalid cleanup time, skipping container"), 3)
+}
+
+func TestMonitorCleanupExitCodes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	job := types.Container{
+		ID:     "job",
+		Names:  []string{"/" + testContainerNamePrefix + "_job"},
+		State:  ExitedState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "mon.checks.cleanup.code": "0,3"},
+	}
+	typo := types.Container{
+		ID:     "typo",
+		Names:  []string{"/" + testContainerNamePrefix + "_typo"},
+		State:  ExitedState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "mon.checks.cleanup.code": "O"},
+	}
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Return([]types.Container{job, typo}, nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(job)).
+		Times(1).
+		Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    "job",
+				State: &types.ContainerState{Status: ExitedState, ExitCode: 3},
+			},
+		}, nil)
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(job), gomock.Any()).
+		Times(1).
+		Return(nil)
+
+	var out bytes.Buffer
+	monitor := Monitor{
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  m,
+		Log:      NewLogger(&out, InfoLevel, false),
+	}
+
+	// the typo isn't taken as 0, the container is left alone instead
+	results, err := monitor.handleContainerCleanup(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(results), 2)
+	assert.Contains(t, out.String(), "Invalid cleanup exit codes, skipping container")
+	assert.Contains(t, out.String(), "container_id=typo")
+}
+
+func TestMonitorCleanupStuck(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	labels := func(extra ...string) map[string]string {
+		l := map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1"}
+		for i := 0; i < len(extra); i += 2 {
+			l[extra[i]] = extra[i+1]
+		}
+		return l
+	}
+	stuck := func(id string, state string, l map[string]string) types.Container {
+		return types.Container{ID: id, Names: []string{"/" + testContainerNamePrefix + "_" + id}, State: state, Labels: l}
+	}
+	inspect := func(id string, state string, created time.Duration, finished time.Duration) types.ContainerJSON {
+		data := types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:      id,
+				Created: now.Add(-created).Format(time.RFC3339Nano),
+				State:   &types.ContainerState{Status: state, FinishedAt: "0001-01-01T00:00:00Z"},
+			},
+		}
+		if finished > 0 {
+			data.State.FinishedAt = now.Add(-finished).Format(time.RFC3339Nano)
+		}
+		return data
+	}
+
+	oldCreated := stuck("oldcreated", CreatedState, labels())
+	newCreated := stuck("newcreated", CreatedState, labels())
+	keptCreated := stuck("keptcreated", CreatedState, labels(CleanupCreatedAfterLabelKey, "0"))
+	dead := stuck("dead", DeadState, labels(CleanupDeadAfterLabelKey, "1h"))
+	ignoredDead := stuck("ignoreddead", DeadState, labels())
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Return([]types.Container{oldCreated, newCreated, keptCreated, dead, ignoredDead}, nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(oldCreated)).
+		Times(1).
+		Return(inspect("oldcreated", CreatedState, 2*time.Hour, 0), nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(newCreated)).
+		Times(1).
+		Return(inspect("newcreated", CreatedState, 10*time.Minute, 0), nil)
+	// it's only been dead for 2h, but was created well before that
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(dead)).
+		Times(1).
+		Return(inspect("dead", DeadState, 48*time.Hour, 2*time.Hour), nil)
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(oldCreated), gomock.Eq(types.ContainerRemoveOptions{})).
+		Times(1).
+		Return(nil)
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(dead), gomock.Eq(types.ContainerRemoveOptions{Force: true})).
+		Times(1).
+		Return(nil)
+
+	notifier := &mockNotifier{}
+	var out bytes.Buffer
+	monitor := Monitor{
+		Selector:              &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:               m,
+		Log:                   NewLogger(&out, InfoLevel, false),
+		Notifiers:             []Notifier{notifier},
+		CleanupCreatedAfterMs: 60 * 60 * 1000,
+		clock:                 func() time.Time { return now },
+	}
+
+	monitor.handleContainerCleanup(context.Background())
+
+	assert.Equal(t, len(notifier.notifications), 2)
+	states := map[string]string{}
+	for _, n := range notifier.notifications {
+		states[n.ContainerID] = n.State
+	}
+	assert.Equal(t, states["oldcreated"], CreatedState)
+	assert.Equal(t, states["dead"], DeadState)
+	assert.Contains(t, out.String(), "state=dead")
 }
 
 func TestMonitorHandleHealthCheckOk(t *testing.T) {
@@ -219,7 +539,7 @@ func TestMonitorHandleHealthCheckOk(t *testing.T) {
 
 	m.
 		EXPECT().
-		ExecuteListQuery(gomock.Eq([]string{
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
 			ObserveLabel,
 			CheckHealthLabel,
 		})).
@@ -229,41 +549,41 @@ func TestMonitorHandleHealthCheckOk(t *testing.T) {
 		}, testContainers), nil)
 	m.
 		EXPECT().
-		Inspect(gomock.Eq(testContainers[4])).
+		Inspect(gomock.Any(), gomock.Eq(testContainers[4])).
 		Times(1).
 		Return(testData[4], nil)
 	m.
 		EXPECT().
-		Inspect(gomock.Eq(testContainers[5])).
+		Inspect(gomock.Any(), gomock.Eq(testContainers[5])).
 		Times(1).
 		Return(testData[5], nil)
 	m.
 		EXPECT().
-		Inspect(gomock.Eq(testContainers[6])).
+		Inspect(gomock.Any(), gomock.Eq(testContainers[6])).
 		Times(1).
 		Return(testData[6], nil)
 	m.
 		EXPECT().
-		Inspect(gomock.Eq(testContainers[7])).
+		Inspect(gomock.Any(), gomock.Eq(testContainers[7])).
 		Times(1).
 		Return(testData[7], nil)
 	m.
 		EXPECT().
-		Restart(gomock.Eq(DefaultRestartTimeoutMs), gomock.Eq(testContainers[4])).
+		Restart(gomock.Any(), gomock.Eq(DefaultRestartTimeoutMs), gomock.Eq(testContainers[4])).
 		Times(1).
 		Return(nil)
 	m.
 		EXPECT().
-		Restart(gomock.Eq(nonstandardTimeoutValue), gomock.Eq(testContainers[5])).
+		Restart(gomock.Any(), gomock.Eq(nonstandardTimeoutValue), gomock.Eq(testContainers[5])).
 		Times(1).
 		Return(nil)
 
 	monitor := Monitor{
-		ContainerPrefix: testContainerNamePrefix,
-		Dockerd:         m,
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  m,
 	}
 
-	monitor.handleContainerHealth()
+	monitor.handleContainerHealth(context.Background())
 }
 
 func TestMonitorPollOk(t *testing.T) {
@@ -274,7 +594,7 @@ func TestMonitorPollOk(t *testing.T) {
 
 	m.
 		EXPECT().
-		ExecuteListQuery(gomock.Eq([]string{
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
 			ObserveLabel,
 			CheckCleanupLabel,
 		})).
@@ -282,7 +602,7 @@ func TestMonitorPollOk(t *testing.T) {
 		Return([]types.Container{}, errors.New("test failure"))
 	m.
 		EXPECT().
-		ExecuteListQuery(gomock.Eq([]string{
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
 			ObserveLabel,
 			CheckHealthLabel,
 		})).
@@ -290,12 +610,12 @@ func TestMonitorPollOk(t *testing.T) {
 		Return([]types.Container{}, errors.New("test failure"))
 
 	monitor := Monitor{
-		ContainerPrefix: testContainerNamePrefix,
-		Dockerd:         m,
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  m,
 	}
 
 	// will swallow the errors
-	monitor.Poll(time.Now())
+	monitor.Poll(context.Background(), time.Now())
 }
 
 // jsonToSingleContainer does a non-production-quality mapping between the types
@@ -336,3 +656,354 @@ func filterContainers(filterMap map[string]string, conts []types.Container) []ty
 
 	return res
 }
+
+func TestMonitorHandleEventOk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	unhealthy := containerEvent(testData[4], HealthStatusEvent+": "+types.Unhealthy)
+	unhealthyCont := eventToContainer(unhealthy)
+	unhealthyCont.State = RunningState
+
+	died := containerEvent(testData[0], DieEvent)
+	diedCont := eventToContainer(died)
+	diedCont.State = ExitedState
+
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(unhealthyCont)).
+		Times(1).
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(DefaultRestartTimeoutMs), gomock.Eq(unhealthyCont)).
+		Times(1).
+		Return(nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(diedCont)).
+		Times(1).
+		Return(testData[0], nil)
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(diedCont), gomock.Eq(types.ContainerRemoveOptions{})).
+		Times(1).
+		Return(nil)
+
+	monitor := Monitor{
+		Dockerd: m,
+	}
+
+	monitor.HandleEvent(context.Background(), unhealthy)
+	// healthy transitions and containers without the check label are ignored
+	monitor.HandleEvent(context.Background(), containerEvent(testData[6], HealthStatusEvent+": "+types.Healthy))
+	monitor.HandleEvent(context.Background(), containerEvent(testData[2], DieEvent))
+	monitor.HandleEvent(context.Background(), died)
+	// once removed, the trailing stop event has nothing to act on
+	monitor.HandleEvent(context.Background(), containerEvent(testData[0], StopEvent))
+	monitor.HandleEvent(context.Background(), containerEvent(testData[0], DestroyEvent))
+}
+
+func TestMonitorHandleEventRestarted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	monitor := Monitor{
+		Dockerd: m,
+	}
+
+	// by the time the die event's handled, the restart policy has already started it again - so it isn't removed
+	for _, status := range []string{RunningState, "restarting"} {
+		died := containerEvent(testData[0], DieEvent)
+		diedCont := eventToContainer(died)
+		diedCont.State = ExitedState
+
+		base := *testData[0].ContainerJSONBase
+		state := *base.State
+		state.Status = status
+		base.State = &state
+		inspect := testData[0]
+		inspect.ContainerJSONBase = &base
+
+		m.
+			EXPECT().
+			Inspect(gomock.Any(), gomock.Eq(diedCont)).
+			Times(1).
+			Return(inspect, nil)
+
+		monitor.HandleEvent(context.Background(), died)
+	}
+}
+
+func TestMonitorPollPrunesRemoved(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	listed := types.Container{
+		ID:     "listed",
+		Names:  []string{"/listed"},
+		State:  RunningState,
+		Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1"},
+	}
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{ObserveLabel, CheckHealthLabel})).
+		Times(1).
+		Return(nil, nil)
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{ObserveLabel, CheckCleanupLabel})).
+		Times(1).
+		Return([]types.Container{listed}, nil)
+
+	monitor := Monitor{
+		Dockerd: m,
+	}
+
+	// removals whose destroy event was missed are only forgotten once they're gone
+	monitor.markRemoved("listed")
+	monitor.markRemoved("gone")
+
+	monitor.Poll(context.Background(), time.Now())
+
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	assert.DeepEqual(t, monitor.removed, map[string]bool{"listed": true})
+}
+
+func TestMonitorDryRunNotRemoved(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	died := containerEvent(testData[0], DieEvent)
+	diedCont := eventToContainer(died)
+	diedCont.State = ExitedState
+
+	// the container's still there after a dry run's removal, so each of its events is checked again
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(diedCont)).
+		Times(2).
+		Return(testData[0], nil)
+
+	monitor := Monitor{
+		Dockerd: &DryRunDockerAPI{Dockerd: m},
+	}
+
+	monitor.HandleEvent(context.Background(), died)
+	monitor.HandleEvent(context.Background(), died)
+
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	assert.Equal(t, len(monitor.removed), 0)
+}
+
+// containerEvent builds the event docker would emit for the given container
+func containerEvent(json types.ContainerJSON, action string) events.Message {
+	attrs := map[string]string{
+		"name": json.Name,
+	}
+	for k, v := range json.Config.Labels {
+		attrs[k] = v
+	}
+
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor: events.Actor{
+			ID:         json.ID,
+			Attributes: attrs,
+		},
+	}
+}
+
+func TestMonitorHealthCrashLoop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	cont := testContainers[4]
+	cont.Labels = map[string]string{
+		"mon.observe":                    "1",
+		"mon.checks.health":              "1",
+		"mon.checks.health.max-restarts": "2",
+		"mon.checks.health.window":       "3600000",
+	}
+
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(cont)).
+		AnyTimes().
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(DefaultRestartTimeoutMs), gomock.Eq(cont)).
+		Times(2).
+		Return(nil)
+
+	now := time.Now()
+	monitor := Monitor{
+		Dockerd: m,
+		clock: func() time.Time {
+			return now
+		},
+	}
+
+	notifier := mockNotifier{}
+	monitor.Notifiers = []Notifier{&notifier}
+
+	// first restart is immediate
+	monitor.checkContainerHealth(context.Background(), cont)
+	assert.Equal(t, len(monitor.restarts[cont.ID].restarts), 1)
+
+	// the second is held back until the backoff has elapsed
+	monitor.checkContainerHealth(context.Background(), cont)
+	assert.Equal(t, len(monitor.restarts[cont.ID].restarts), 1)
+	now = now.Add(time.Duration(RestartBackoffMs) * time.Millisecond)
+	monitor.checkContainerHealth(context.Background(), cont)
+	assert.Equal(t, len(monitor.restarts[cont.ID].restarts), 2)
+
+	// the limit is hit, so we give up regardless of backoff
+	now = now.Add(time.Duration(MaxRestartBackoffMs) * time.Millisecond)
+	monitor.checkContainerHealth(context.Background(), cont)
+	monitor.checkContainerHealth(context.Background(), cont)
+	assert.Equal(t, monitor.restarts[cont.ID].givenUp, true)
+	assert.Equal(t, len(notifier.notifications), 3)
+	assert.Equal(t, notifier.notifications[0].Action, RestartAction)
+	assert.Equal(t, notifier.notifications[2].Action, GiveUpAction)
+	assert.Equal(t, notifier.notifications[2].ContainerName, "test_cont_mno")
+
+	// destroying the container forgets all of it
+	monitor.HandleEvent(context.Background(), containerEvent(testData[4], DestroyEvent))
+	_, ok := monitor.restarts[cont.ID]
+	assert.Equal(t, ok, false)
+}
+
+func TestMonitorHealthInvalidLabels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	cont := testContainers[4]
+	cont.Labels = map[string]string{
+		"mon.observe":                    "1",
+		"mon.checks.health":              "1",
+		"mon.checks.health.timeout":      "soon",
+		"mon.checks.health.max-restarts": "two",
+	}
+
+	// the defaults are used, with a warning for each typo
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(cont)).
+		Times(1).
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(DefaultRestartTimeoutMs), gomock.Eq(cont)).
+		Times(1).
+		Return(nil)
+
+	var out bytes.Buffer
+	monitor := Monitor{
+		Dockerd: m,
+		Log:     NewLogger(&out, WarnLevel, false),
+	}
+
+	monitor.checkContainerHealth(context.Background(), cont)
+
+	assert.Contains(t, out.String(), "Invalid restart timeout, using the default")
+	assert.Contains(t, out.String(), "Invalid max restarts, using the default")
+	assert.Contains(t, out.String(), "max_restarts=two")
+	assert.Contains(t, out.String(), "container_name=")
+}
+
+func TestMonitorConfigure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	// the settings can't change under a poll, so configuring waits for it to finish
+	listing := make(chan bool)
+	unblock := make(chan bool)
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckHealthLabel,
+		})).
+		Times(1).
+		DoAndReturn(func(ctx context.Context, filterList []string) ([]types.Container, error) {
+			close(listing)
+			<-unblock
+			return []types.Container{}, nil
+		})
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckCleanupLabel,
+		})).
+		Times(1).
+		Return([]types.Container{}, nil)
+
+	monitor := Monitor{
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  m,
+	}
+
+	polled := make(chan bool)
+	go func() {
+		monitor.Poll(context.Background(), time.Now())
+		close(polled)
+	}()
+	<-listing
+
+	configured := make(chan bool)
+	go func() {
+		monitor.Configure(MonitorSettings{
+			Selector:    &Selector{Include: []string{"other*"}},
+			Concurrency: 2,
+		})
+		close(configured)
+	}()
+
+	select {
+	case <-configured:
+		assert.NotNil(t, errors.New("configured during a poll"))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(unblock)
+	<-polled
+	<-configured
+
+	assert.Equal(t, monitor.Selector.String(), "include=other*")
+	assert.Equal(t, monitor.Concurrency, 2)
+
+	// which now filters out the test containers
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckHealthLabel,
+		})).
+		Times(1).
+		Return(testContainers, nil)
+
+	conts, err := monitor.listContainers(context.Background(), CheckHealthLabel)
+	assert.NilError(t, err)
+	assert.Equal(t, len(conts), 0)
+}
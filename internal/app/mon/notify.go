@@ -0,0 +1,215 @@
+package mon
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// DefaultNotifyTimeoutMs is the default timeout for delivering a single notification
+const DefaultNotifyTimeoutMs int64 = 10 * 1000
+
+// RestartAction is reported when a container is restarted
+const RestartAction string = "restart"
+
+// RemoveAction is reported when a container is removed
+const RemoveAction string = "remove"
+
+// GiveUpAction is reported when we stop restarting a crash-looping container
+const GiveUpAction string = "give-up"
+
+// RemoveImageAction is logged (and counted in poll summaries) when an unused image is removed
+const RemoveImageAction string = "remove-image"
+
+// RemoveVolumeAction is logged (and counted in poll summaries) when an unused volume is removed
+const RemoveVolumeAction string = "remove-volume"
+
+// RemoveNetworkAction is logged (and counted in poll summaries) when an unused network is removed
+const RemoveNetworkAction string = "remove-network"
+
+// HealthCheck names the health check, in notifications and metrics
+const HealthCheck string = "health"
+
+// CleanupCheck names the cleanup check, in notifications and metrics
+const CleanupCheck string = "cleanup"
+
+var actionsPast = map[string]string{
+	RestartAction: "restarted",
+	RemoveAction:  "removed",
+	GiveUpAction:  "gave up on",
+}
+
+// Notification describes an action mon took (or failed to take) on a container
+type Notification struct {
+	Host          string `json:"host,omitempty"`
+	Action        string `json:"action"`
+	Check         string `json:"check"`
+	ContainerID   string `json:"containerId"`
+	ContainerName string `json:"containerName"`
+	// State is the state the container was found in, when the action was taken
+	State string    `json:"state,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// String summarizes the notification for humans
+func (n Notification) String() string {
+	container := fmt.Sprintf("container %s (%s)", n.ContainerName, n.ContainerID)
+	if len(n.State) > 0 {
+		container = n.State + " " + container
+	}
+	if len(n.Host) > 0 {
+		container += " on " + n.Host
+	}
+
+	if len(n.Error) > 0 {
+		return fmt.Sprintf("mon failed to %s %s for %s check: %s", n.Action, container, n.Check, n.Error)
+	}
+
+	return fmt.Sprintf("mon %s %s for %s check", actionsPast[n.Action], container, n.Check)
+}
+
+// Notifier is told about every action mon takes, and every action that fails
+type Notifier interface {
+	Notify(Notification) error
+}
+
+// WebhookNotifier posts each notification as JSON to a url
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts the notification
+func (w *WebhookNotifier) Notify(n Notification) error {
+	return postJSON(w.Client, w.URL, n)
+}
+
+// SlackNotifier posts each notification to a slack-compatible incoming webhook
+type SlackNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts the notification as a slack message
+func (s *SlackNotifier) Notify(n Notification) error {
+	return postJSON(s.Client, s.URL, map[string]string{
+		"text": n.String(),
+	})
+}
+
+// EmailNotifier sends each notification as an email, over SMTP
+type EmailNotifier struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+	// TimeoutMs bounds connecting to the server and the whole conversation with it, zero uses the default
+	TimeoutMs int64
+}
+
+// Notify sends the notification, upgrading to TLS when the server supports it
+func (e *EmailNotifier) Notify(n Notification) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+
+	timeout := msOrDefault(e.TimeoutMs, DefaultNotifyTimeoutMs)
+
+	conn, err := net.DialTimeout("tcp", e.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// notifications are sent from within a check, so a server that stops responding mustn't hold it up for long
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if len(e.Username) > 0 {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(e.From, e.To, n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func emailMessage(from string, to []string, n Notification) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: [mon] %s %s\r\n", n.Action, n.ContainerName)
+	fmt.Fprintf(&buf, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "\r\n%s\r\n", n.String())
+
+	return buf.Bytes()
+}
+
+func postJSON(client *http.Client, url string, body interface{}) error {
+	if client == nil {
+		client = &http.Client{
+			Timeout: time.Duration(DefaultNotifyTimeoutMs) * time.Millisecond,
+		}
+	}
+
+	dat, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Post(url, "application/json", bytes.NewReader(dat))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response status: %v", res.Status)
+	}
+
+	return nil
+}
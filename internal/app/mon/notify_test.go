@@ -0,0 +1,190 @@
+package mon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+var testNotification = Notification{
+	Action:        RestartAction,
+	Check:         HealthCheck,
+	ContainerID:   "abc",
+	ContainerName: "test_cont_abc",
+	Time:          time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC),
+}
+
+type mockNotifier struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+func (n *mockNotifier) Notify(notification Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+// captureServer records the body of every request made to it
+func captureServer(status int, bodies *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dat, _ := ioutil.ReadAll(r.Body)
+		*bodies = append(*bodies, string(dat))
+		w.WriteHeader(status)
+	}))
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var bodies []string
+	server := captureServer(http.StatusOK, &bodies)
+	defer server.Close()
+
+	notifier := WebhookNotifier{URL: server.URL}
+	assert.NilError(t, notifier.Notify(testNotification))
+	assert.Equal(t, len(bodies), 1)
+
+	var got Notification
+	assert.NilError(t, json.Unmarshal([]byte(bodies[0]), &got))
+	assert.DeepEqual(t, got, testNotification)
+}
+
+func TestWebhookNotifierErr(t *testing.T) {
+	var bodies []string
+	server := captureServer(http.StatusInternalServerError, &bodies)
+	defer server.Close()
+
+	notifier := WebhookNotifier{URL: server.URL}
+	assert.Error(t, notifier.Notify(testNotification), "500")
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var bodies []string
+	server := captureServer(http.StatusOK, &bodies)
+	defer server.Close()
+
+	failed := testNotification
+	failed.Error = "test failure"
+
+	notifier := SlackNotifier{URL: server.URL}
+	assert.NilError(t, notifier.Notify(testNotification))
+	assert.NilError(t, notifier.Notify(failed))
+	assert.Equal(t, len(bodies), 2)
+
+	var msg map[string]string
+	assert.NilError(t, json.Unmarshal([]byte(bodies[0]), &msg))
+	assert.Equal(t, msg["text"], "mon restarted container test_cont_abc (abc) for health check")
+	assert.NilError(t, json.Unmarshal([]byte(bodies[1]), &msg))
+	assert.Equal(t, msg["text"], "mon failed to restart container test_cont_abc (abc) for health check: test failure")
+}
+
+func TestNotificationString(t *testing.T) {
+	dead := testNotification
+	dead.Action = RemoveAction
+	dead.Check = CleanupCheck
+	dead.State = DeadState
+	dead.Host = "edge"
+
+	assert.Equal(t, dead.String(), "mon removed dead container test_cont_abc (abc) on edge for cleanup check")
+}
+
+func TestEmailMessage(t *testing.T) {
+	msg := string(emailMessage("mon@example.com", []string{"a@example.com", "b@example.com"}, testNotification))
+
+	assert.Contains(t, msg, "From: mon@example.com\r\n")
+	assert.Contains(t, msg, "To: a@example.com, b@example.com\r\n")
+	assert.Contains(t, msg, "Subject: [mon] restart test_cont_abc\r\n")
+	assert.Equal(t, strings.HasSuffix(msg, "\r\n\r\nmon restarted container test_cont_abc (abc) for health check\r\n"), true)
+}
+
+func TestEmailNotifierTimeout(t *testing.T) {
+	// accepts the connection, but never greets us
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	notifier := EmailNotifier{
+		Addr:      listener.Addr().String(),
+		From:      "mon@example.com",
+		To:        []string{"a@example.com"},
+		TimeoutMs: 50,
+	}
+
+	start := time.Now()
+	assert.Error(t, notifier.Notify(testNotification), "i/o timeout")
+	assert.Equal(t, time.Since(start) < time.Second, true)
+}
+
+func TestEmailNotifierSends(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+
+	// just enough of a server to take one message, without STARTTLS or AUTH
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 test")
+
+		var lines []string
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				break
+			}
+			lines = append(lines, line)
+
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				text.PrintfLine("250 test")
+			case line == "DATA":
+				text.PrintfLine("354 go ahead")
+				body, _ := text.ReadDotLines()
+				lines = append(lines, body...)
+				text.PrintfLine("250 ok")
+			case line == "QUIT":
+				text.PrintfLine("221 bye")
+				received <- lines
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	notifier := EmailNotifier{
+		Addr: listener.Addr().String(),
+		From: "mon@example.com",
+		To:   []string{"a@example.com", "b@example.com"},
+	}
+	assert.NilError(t, notifier.Notify(testNotification))
+
+	lines := strings.Join(<-received, "\n")
+	assert.Contains(t, lines, "MAIL FROM:<mon@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<b@example.com>")
+	assert.Contains(t, lines, "Subject: [mon] restart test_cont_abc")
+}
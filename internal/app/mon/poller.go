@@ -1,42 +1,68 @@
 package mon
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"time"
 )
 
 // PollHandler provides a poll method
 type PollHandler interface {
-	Poll(time.Time)
+	Poll(context.Context, time.Time)
 }
 
 // Poller polls the docker socket at an interval, to find containers
 type Poller struct {
 	IntervalMs     int64
 	Handler        PollHandler
+	Log            *Logger
 	tickerComplete chan bool
+	cancel         context.CancelFunc
 	ticker         *time.Ticker
 	running        bool
+	mu             sync.Mutex
+	paused         bool
+	reset          chan bool
 }
 
-// Start begins polling
-func (p *Poller) Start() error {
-	if p.running {
+// Start begins polling, until Stop is called or ctx is done
+func (p *Poller) Start(ctx context.Context) error {
+	if p.isRunning() {
 		return errors.New("Already running")
 	}
 
+	ctx, p.cancel = context.WithCancel(ctx)
 	p.tickerComplete = make(chan bool)
-	p.ticker = time.NewTicker(time.Duration(p.IntervalMs) * time.Millisecond)
-	p.running = true
+	p.reset = make(chan bool, 1)
+	p.ticker = time.NewTicker(time.Duration(p.Interval()) * time.Millisecond)
+	p.setRunning(true)
+
+	p.Log.With(Fields{"interval_ms": p.Interval()}).Debug("Poller started")
 
 	// run the ticker
 	go func() {
+		defer close(p.tickerComplete)
+
 		for {
 			select {
-			case <-p.tickerComplete:
+			case <-ctx.Done():
 				return
+			case <-p.reset:
+				p.ticker.Stop()
+				p.ticker = time.NewTicker(time.Duration(p.Interval()) * time.Millisecond)
 			case t := <-p.ticker.C:
-				p.Handler.Poll(t)
+				// a tick can be ready alongside cancellation, don't start a poll that's already cancelled
+				if ctx.Err() != nil {
+					return
+				}
+
+				if p.Paused() {
+					p.Log.With(Fields{"poll": t}).Debug("Poller paused, skipping poll")
+					continue
+				}
+
+				p.Handler.Poll(ctx, t)
 			}
 		}
 	}()
@@ -46,13 +72,91 @@ func (p *Poller) Start() error {
 
 // Stop ends polling
 func (p *Poller) Stop() error {
-	if !p.running {
+	if !p.isRunning() {
 		return errors.New("Not running")
 	}
 
-	p.tickerComplete <- true
+	// interrupt any in-flight poll, so we don't wait on it
+	p.cancel()
+	<-p.tickerComplete
 	p.ticker.Stop()
-	p.running = false
+	p.setRunning(false)
+
+	p.Log.Debug("Poller stopped")
 
 	return nil
 }
+
+// isRunning is true between Start and Stop, SetInterval checks it from other goroutines
+func (p *Poller) isRunning() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.running
+}
+
+func (p *Poller) setRunning(running bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running = running
+}
+
+// Interval is the current poll interval (in ms)
+func (p *Poller) Interval() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.IntervalMs
+}
+
+// SetInterval changes the poll interval (in ms), restarting the ticker if we're running
+func (p *Poller) SetInterval(ms int64) {
+	p.mu.Lock()
+	changed := p.IntervalMs != ms
+	p.IntervalMs = ms
+	running := p.running
+	p.mu.Unlock()
+
+	if !changed || !running {
+		return
+	}
+
+	p.Log.With(Fields{"interval_ms": ms}).Info("Poll interval changed")
+
+	// the ticker goroutine picks this up, if it hasn't already got a reset pending
+	select {
+	case p.reset <- true:
+	default:
+	}
+}
+
+// Pause skips polls until Resume is called, without stopping the poller
+func (p *Poller) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		p.Log.Info("Poller paused")
+	}
+	p.paused = true
+}
+
+// Resume undoes Pause
+func (p *Poller) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused {
+		p.Log.Info("Poller resumed")
+	}
+	p.paused = false
+}
+
+// Paused is true while polls are being skipped
+func (p *Poller) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.paused
+}
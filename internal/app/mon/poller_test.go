@@ -1,6 +1,7 @@
 package mon
 
 import (
+	"context"
 	"errors"
 	"log"
 	"testing"
@@ -21,8 +22,8 @@ func TestPoll(t *testing.T) {
 		Handler:    &handler,
 	}
 
-	assert.NilError(t, poll.Start())
-	assert.Error(t, poll.Start(), "Already running")
+	assert.NilError(t, poll.Start(context.Background()))
+	assert.Error(t, poll.Start(context.Background()), "Already running")
 	time.Sleep(2 * time.Second)
 	assert.NilError(t, poll.Stop())
 	assert.Error(t, poll.Stop(), "Not running")
@@ -33,6 +34,99 @@ func TestPoll(t *testing.T) {
 	}
 }
 
-func (m *mockHandler) Poll(t time.Time) {
+func (m *mockHandler) Poll(ctx context.Context, t time.Time) {
 	m.count++
 }
+
+type blockingHandler struct {
+	cancelled chan bool
+}
+
+func (b *blockingHandler) Poll(ctx context.Context, t time.Time) {
+	<-ctx.Done()
+
+	select {
+	case b.cancelled <- true:
+	default:
+	}
+}
+
+func TestPollStopCancelsInFlight(t *testing.T) {
+	handler := blockingHandler{cancelled: make(chan bool, 1)}
+
+	poll := Poller{
+		IntervalMs: 10,
+		Handler:    &handler,
+	}
+
+	assert.NilError(t, poll.Start(context.Background()))
+	time.Sleep(100 * time.Millisecond)
+
+	// the handler only returns once its context is cancelled, so this would hang if Stop didn't cancel it
+	assert.NilError(t, poll.Stop())
+	assert.Equal(t, <-handler.cancelled, true)
+}
+
+func TestPollPause(t *testing.T) {
+	handler := mockHandler{}
+
+	poll := Poller{
+		IntervalMs: 10,
+		Handler:    &handler,
+	}
+
+	poll.Pause()
+	assert.Equal(t, poll.Paused(), true)
+
+	assert.NilError(t, poll.Start(context.Background()))
+	time.Sleep(100 * time.Millisecond)
+	assert.NilError(t, poll.Stop())
+	assert.Equal(t, handler.count, 0)
+
+	poll.Resume()
+	assert.Equal(t, poll.Paused(), false)
+
+	assert.NilError(t, poll.Start(context.Background()))
+	time.Sleep(100 * time.Millisecond)
+	assert.NilError(t, poll.Stop())
+	assert.Equal(t, handler.count > 0, true)
+}
+
+func TestPollSetInterval(t *testing.T) {
+	handler := mockHandler{}
+
+	poll := Poller{
+		IntervalMs: 1000,
+		Handler:    &handler,
+	}
+
+	assert.NilError(t, poll.Start(context.Background()))
+	poll.SetInterval(10)
+	assert.Equal(t, poll.Interval(), int64(10))
+	time.Sleep(200 * time.Millisecond)
+	assert.NilError(t, poll.Stop())
+
+	// with the original interval, we wouldn't have polled at all yet
+	assert.Equal(t, handler.count > 5, true)
+}
+
+func TestPollSetIntervalWhileStopping(t *testing.T) {
+	poll := Poller{
+		IntervalMs: 1000,
+		Handler:    &mockHandler{},
+	}
+
+	assert.NilError(t, poll.Start(context.Background()))
+
+	// a reload can change the interval while the poller is being stopped
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		poll.SetInterval(10)
+	}()
+
+	assert.NilError(t, poll.Stop())
+	<-done
+
+	assert.Equal(t, poll.Interval(), int64(10))
+}
@@ -0,0 +1,69 @@
+package mon
+
+import (
+	"time"
+)
+
+// HealthMaxRestartsLabelKey is the label key in which the max restart count (within the window) can be overriden
+const HealthMaxRestartsLabelKey string = "mon.checks.health.max-restarts"
+
+// HealthRestartWindowLabelKey is the label key in which the restart counting window (in ms) can be overriden
+const HealthRestartWindowLabelKey string = "mon.checks.health.window"
+
+// DefaultMaxRestarts is the default number of restarts allowed within the window, before we give up
+const DefaultMaxRestarts int = 5
+
+// DefaultRestartWindowMs is the default window in which restarts are counted
+const DefaultRestartWindowMs int64 = 10 * 60 * 1000
+
+// RestartBackoffMs is the delay after the first restart, which doubles with each restart in the window
+const RestartBackoffMs int64 = 5 * 1000
+
+// MaxRestartBackoffMs caps the delay between restarts
+const MaxRestartBackoffMs int64 = 5 * 60 * 1000
+
+// restartHistory tracks the restarts we've issued for a single container
+type restartHistory struct {
+	restarts []time.Time
+	givenUp  bool
+}
+
+// prune forgets restarts that fall outside the window
+func (h *restartHistory) prune(now time.Time, window time.Duration) {
+	kept := h.restarts[:0]
+
+	for _, t := range h.restarts {
+		if now.Sub(t) < window {
+			kept = append(kept, t)
+		}
+	}
+
+	h.restarts = kept
+}
+
+// backoff is how much longer we must wait before the next restart is allowed
+func (h *restartHistory) backoff(now time.Time) time.Duration {
+	if len(h.restarts) == 0 {
+		return 0
+	}
+
+	delayMs := RestartBackoffMs
+	for i := 1; i < len(h.restarts) && delayMs < MaxRestartBackoffMs; i++ {
+		delayMs *= 2
+	}
+	if delayMs > MaxRestartBackoffMs {
+		delayMs = MaxRestartBackoffMs
+	}
+
+	last := h.restarts[len(h.restarts)-1]
+	wait := last.Add(time.Duration(delayMs) * time.Millisecond).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+func (h *restartHistory) record(now time.Time) {
+	h.restarts = append(h.restarts, now)
+}
@@ -0,0 +1,44 @@
+package mon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestRestartHistoryBackoff(t *testing.T) {
+	now := time.Now()
+	history := restartHistory{}
+
+	assert.Equal(t, history.backoff(now), time.Duration(0))
+
+	history.record(now)
+	assert.Equal(t, history.backoff(now), time.Duration(RestartBackoffMs)*time.Millisecond)
+
+	history.record(now)
+	assert.Equal(t, history.backoff(now), 2*time.Duration(RestartBackoffMs)*time.Millisecond)
+
+	// once enough time has passed, there's nothing left to wait for
+	assert.Equal(t, history.backoff(now.Add(time.Hour)), time.Duration(0))
+
+	for i := 0; i < 20; i++ {
+		history.record(now)
+	}
+	assert.Equal(t, history.backoff(now), time.Duration(MaxRestartBackoffMs)*time.Millisecond)
+}
+
+func TestRestartHistoryPrune(t *testing.T) {
+	now := time.Now()
+	history := restartHistory{}
+
+	history.record(now.Add(-2 * time.Minute))
+	history.record(now.Add(-30 * time.Second))
+	history.record(now)
+
+	history.prune(now, time.Minute)
+	assert.Equal(t, len(history.restarts), 2)
+
+	history.prune(now.Add(time.Hour), time.Minute)
+	assert.Equal(t, len(history.restarts), 0)
+}
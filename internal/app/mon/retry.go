@@ -0,0 +1,154 @@
+package mon
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// DefaultRetryAttempts is the default max number of attempts for a docker call
+const DefaultRetryAttempts int64 = 10
+
+// DefaultRetryIntervalMs is the default delay before the first retry
+const DefaultRetryIntervalMs int64 = 100
+
+// DefaultRetryMaxIntervalMs is the default cap on the delay between retries
+const DefaultRetryMaxIntervalMs int64 = 5 * 1000
+
+// DefaultRetryMaxElapsedMs is the default time after which we stop retrying
+const DefaultRetryMaxElapsedMs int64 = 20 * 1000
+
+// DefaultRetryMultiplier is the default growth of the delay with each retry
+const DefaultRetryMultiplier float64 = 2
+
+// DefaultRetryJitter is the default fraction by which each delay is randomized
+const DefaultRetryJitter float64 = 0.2
+
+// RetryPolicy controls how failed docker calls are retried, zero values use the defaults
+type RetryPolicy struct {
+	MaxAttempts   int64
+	IntervalMs    int64
+	MaxIntervalMs int64
+	MaxElapsedMs  int64
+	Multiplier    float64
+	// Jitter randomizes each delay by up to +/- this fraction of it, set it negative to disable
+	Jitter float64
+}
+
+// RetryError is returned when a call fails for good, keeping the last error and how many attempts were made
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the last error
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Do calls fn until it succeeds, fails permanently, or the policy (or ctx) says to stop
+func (p RetryPolicy) Do(ctx context.Context, logger *Logger, fn func() error) error {
+	p = p.withDefaults()
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+
+		if err == nil {
+			return nil
+		}
+
+		// cancelled or timed out, there's no point trying again
+		if ctx.Err() != nil {
+			return &RetryError{Attempts: attempt, Err: ctx.Err()}
+		}
+
+		if !isRetryable(err) || int64(attempt) >= p.MaxAttempts {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+
+		delay := p.delay(attempt)
+		if time.Since(start)+delay > time.Duration(p.MaxElapsedMs)*time.Millisecond {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+
+		logger.With(Fields{"attempt": attempt, "max_attempts": p.MaxAttempts, "delay": delay}).WithError(err).Warn("Retrying")
+
+		select {
+		case <-ctx.Done():
+			return &RetryError{Attempts: attempt, Err: ctx.Err()}
+		case <-time.After(delay):
+		}
+	}
+}
+
+// delay is how long to wait after the given (1-based) attempt failed
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delayMs := float64(p.IntervalMs)
+	for i := 1; i < attempt && delayMs < float64(p.MaxIntervalMs); i++ {
+		delayMs *= p.Multiplier
+	}
+	if delayMs > float64(p.MaxIntervalMs) {
+		delayMs = float64(p.MaxIntervalMs)
+	}
+
+	if p.Jitter > 0 {
+		delayMs += delayMs * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delayMs * float64(time.Millisecond))
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryAttempts
+	}
+	if p.IntervalMs <= 0 {
+		p.IntervalMs = DefaultRetryIntervalMs
+	}
+	if p.MaxIntervalMs <= 0 {
+		p.MaxIntervalMs = DefaultRetryMaxIntervalMs
+	}
+	if p.MaxElapsedMs <= 0 {
+		p.MaxElapsedMs = DefaultRetryMaxElapsedMs
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryMultiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = DefaultRetryJitter
+	}
+
+	return p
+}
+
+// isRetryable classifies errors - the container being gone, a conflicting operation, or a volume or network still in
+// use, won't change by trying again. Failing to reach the daemon at all always might
+func isRetryable(err error) bool {
+	// checked first, a connection error's message can look like the daemon's (e.g. "lookup docker: no such host")
+	if isTransportError(err) {
+		return true
+	}
+
+	if client.IsErrNotFound(err) {
+		return false
+	}
+
+	// the daemon's 404 and 409 responses for most container operations are untyped, so we go by the message
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range []string{"no such", "not found", "conflict", "already in progress", "is in use", "active endpoints"} {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+
+	return true
+}
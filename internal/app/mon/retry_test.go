@@ -0,0 +1,135 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// testRetryPolicy retries quickly, and without jitter, so tests are fast and predictable
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	IntervalMs:  1,
+	Jitter:      -1,
+}
+
+func TestWithRetryOk(t *testing.T) {
+	count := 0
+	err := testRetryPolicy.Do(context.Background(), nil, func() error {
+		count++
+		return nil
+	})
+
+	assert.NilError(t, err)
+	assert.Equal(t, count, 1)
+}
+
+func TestWithRetryErr(t *testing.T) {
+	count := 0
+	cause := errors.New("test fail")
+	err := testRetryPolicy.Do(context.Background(), nil, func() error {
+		count++
+		return cause
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, count, 5)
+
+	// the cause and attempt count are kept
+	var retryErr *RetryError
+	assert.Equal(t, errors.As(err, &retryErr), true)
+	assert.Equal(t, retryErr.Attempts, 5)
+	assert.Equal(t, errors.Is(err, cause), true)
+	assert.Error(t, err, "failed after 5 attempt(s): test fail")
+}
+
+func TestWithRetryPermanentErr(t *testing.T) {
+	for _, msg := range []string{
+		"Error: No such container: abc",
+		"Error response from daemon: Conflict. The container name is already in use",
+		"Error response from daemon: removal of container abc is already in progress",
+		"Error response from daemon: unable to remove volume: remove data: volume is in use - [abc]",
+		"Error response from daemon: error while removing network: network ci_default id def has active endpoints",
+	} {
+		count := 0
+		err := testRetryPolicy.Do(context.Background(), nil, func() error {
+			count++
+			return errors.New(msg)
+		})
+
+		assert.Error(t, err, msg)
+		assert.Equal(t, count, 1)
+	}
+}
+
+func TestWithRetryTransportErr(t *testing.T) {
+	for _, err := range []error{
+		errors.New("error during connect: Get http://docker/v1.41/containers/json: dial tcp: lookup docker: no such host"),
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("lookup docker: no such host")},
+	} {
+		count := 0
+		_ = testRetryPolicy.Do(context.Background(), nil, func() error {
+			count++
+			return err
+		})
+
+		assert.Equal(t, count, 5)
+	}
+}
+
+func TestWithRetryMaxElapsed(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:  100,
+		IntervalMs:   20,
+		MaxElapsedMs: 50,
+		Jitter:       -1,
+	}
+
+	count := 0
+	err := policy.Do(context.Background(), nil, func() error {
+		count++
+		return errors.New("test fail")
+	})
+
+	// 20ms, then 40ms would take us past 50ms
+	assert.NotNil(t, err)
+	assert.Equal(t, count, 2)
+}
+
+func TestWithRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	count := 0
+	err := RetryPolicy{IntervalMs: 1000}.Do(ctx, nil, func() error {
+		count++
+		cancel()
+		return errors.New("test fail")
+	})
+
+	assert.Equal(t, errors.Is(err, context.Canceled), true)
+	assert.Equal(t, count, 1)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{
+		IntervalMs:    100,
+		MaxIntervalMs: 1000,
+		Jitter:        -1,
+	}.withDefaults()
+
+	assert.Equal(t, policy.delay(1), 100*time.Millisecond)
+	assert.Equal(t, policy.delay(2), 200*time.Millisecond)
+	assert.Equal(t, policy.delay(4), 800*time.Millisecond)
+	assert.Equal(t, policy.delay(5), 1000*time.Millisecond)
+	assert.Equal(t, policy.delay(50), 1000*time.Millisecond)
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := policy.delay(1)
+		assert.Equal(t, d >= 50*time.Millisecond && d <= 150*time.Millisecond, true)
+	}
+}
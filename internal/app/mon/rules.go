@@ -0,0 +1,281 @@
+package mon
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ComposeProjectLabelKey is the label compose sets to the project a container belongs to
+const ComposeProjectLabelKey string = "com.docker.compose.project"
+
+// Rule assigns checks (and their parameters) to the containers it matches, as if they had been labelled
+type Rule struct {
+	Name   string     `yaml:"name"`
+	Match  RuleMatch  `yaml:"match"`
+	Checks RuleChecks `yaml:"checks"`
+}
+
+// RuleMatch selects containers - every field that's set must match
+type RuleMatch struct {
+	// Name is a glob (where * and ? match any characters) for the container name
+	Name string `yaml:"name"`
+	// Image is a glob for the image the container was created from
+	Image string `yaml:"image"`
+	// ComposeProject is the compose project the container belongs to
+	ComposeProject string `yaml:"compose_project"`
+	// Labels the container must have, as "key=value" or just "key"
+	Labels []string `yaml:"labels"`
+
+	// name and image are Name and Image compiled, by ValidateRules
+	name  *regexp.Regexp
+	image *regexp.Regexp
+}
+
+// compile compiles the Name and Image globs, so they aren't for every container
+func (m *RuleMatch) compile() {
+	if len(m.Name) > 0 {
+		m.name = globRegexp(m.Name)
+	}
+	if len(m.Image) > 0 {
+		m.image = globRegexp(m.Image)
+	}
+}
+
+// RuleChecks are the checks a rule enables, a nil check isn't enabled (use {} to enable one with defaults)
+type RuleChecks struct {
+	Health  *HealthRule  `yaml:"health"`
+	Cleanup *CleanupRule `yaml:"cleanup"`
+}
+
+// HealthRule sets the parameters of the health check, matching the mon.checks.health.* labels
+type HealthRule struct {
+	// Timeout and Window are in ms or with a unit, as for CleanupRule.After
+	Timeout     *string `yaml:"timeout"`
+	MaxRestarts *int    `yaml:"max_restarts"`
+	Window      *string `yaml:"window"`
+}
+
+// CleanupRule sets the parameters of the cleanup check, matching the mon.checks.cleanup.* labels
+type CleanupRule struct {
+	// Code is the exit codes to clean up after, a single code or a list (see ParseExitCodes)
+	Code *string `yaml:"code"`
+	// After is how long a container has to have exited for, before it's cleaned up, in ms or with a unit (see parseMs)
+	After *string `yaml:"after"`
+	// Created and Dead are how long a container has to be stuck created or dead for, as for After. 0 leaves it alone
+	Created *string `yaml:"created_after"`
+	Dead    *string `yaml:"dead_after"`
+	// Volumes and Links have the container's anonymous volumes, and the links to it, removed along with it
+	Volumes *bool `yaml:"volumes"`
+	Links   *bool `yaml:"links"`
+}
+
+// ValidateRules checks every rule matches something, and enables some check - compiling what it matches with
+func ValidateRules(rules []Rule) error {
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %d (%s): %w", i+1, rule.Name, err)
+		}
+
+		rules[i].Match.compile()
+	}
+
+	return nil
+}
+
+func (r Rule) validate() error {
+	if len(r.Match.Name) == 0 && len(r.Match.Image) == 0 && len(r.Match.ComposeProject) == 0 && len(r.Match.Labels) == 0 {
+		return errors.New("match is empty, use name: \"*\" to match every container")
+	}
+
+	if r.Checks.Health == nil && r.Checks.Cleanup == nil {
+		return errors.New("no checks are enabled")
+	}
+
+	if health := r.Checks.Health; health != nil {
+		if err := validateMs(msField{"timeout", health.Timeout}, msField{"window", health.Window}); err != nil {
+			return fmt.Errorf("health: %w", err)
+		}
+
+		if health.MaxRestarts != nil && *health.MaxRestarts < 0 {
+			return fmt.Errorf("health: max_restarts: must not be negative, got %d", *health.MaxRestarts)
+		}
+	}
+
+	if cleanup := r.Checks.Cleanup; cleanup != nil {
+		if cleanup.Code != nil {
+			if _, err := ParseExitCodes(*cleanup.Code); err != nil {
+				return fmt.Errorf("cleanup: %w", err)
+			}
+		}
+
+		if err := validateMs(msField{"after", cleanup.After}, msField{"created_after", cleanup.Created}, msField{"dead_after", cleanup.Dead}); err != nil {
+			return fmt.Errorf("cleanup: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// msField is one of a rule's times, and what it's called in the rules
+type msField struct {
+	name string
+	raw  *string
+}
+
+// validateMs checks every time that's set parses as it would in a label (see parseMs), and isn't negative
+func validateMs(fields ...msField) error {
+	for _, field := range fields {
+		if field.raw == nil {
+			continue
+		}
+
+		ms, err := parseMs(*field.raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid time '%s', expected ms or a duration like '10m'", field.name, *field.raw)
+		}
+		if ms < 0 {
+			return fmt.Errorf("%s: must not be negative, got '%s'", field.name, *field.raw)
+		}
+	}
+
+	return nil
+}
+
+// matches checks if the rule applies to cont
+func (r Rule) matches(cont types.Container) bool {
+	// a rule that wasn't validated hasn't been compiled yet
+	if (len(r.Match.Name) > 0 && r.Match.name == nil) || (len(r.Match.Image) > 0 && r.Match.image == nil) {
+		r.Match.compile()
+	}
+
+	if r.Match.name != nil {
+		matched := false
+		for _, name := range cont.Names {
+			if r.Match.name.MatchString(strings.TrimPrefix(name, "/")) {
+				matched = true
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if r.Match.image != nil && !r.Match.image.MatchString(cont.Image) {
+		return false
+	}
+
+	if len(r.Match.ComposeProject) > 0 && cont.Labels[ComposeProjectLabelKey] != r.Match.ComposeProject {
+		return false
+	}
+
+	for _, label := range r.Match.Labels {
+		if !labelsContain(cont.Labels, label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// labels are what the rule's checks would look like as container labels
+func (r Rule) labels() map[string]string {
+	labels := map[string]string{}
+
+	setLabel := func(label string) {
+		kv := strings.SplitN(label, "=", 2)
+		labels[kv[0]] = kv[1]
+	}
+
+	setLabel(ObserveLabel)
+
+	if health := r.Checks.Health; health != nil {
+		setLabel(CheckHealthLabel)
+
+		if health.Timeout != nil {
+			labels[HealthRestartLabelKey] = *health.Timeout
+		}
+		if health.MaxRestarts != nil {
+			labels[HealthMaxRestartsLabelKey] = strconv.Itoa(*health.MaxRestarts)
+		}
+		if health.Window != nil {
+			labels[HealthRestartWindowLabelKey] = *health.Window
+		}
+	}
+
+	if cleanup := r.Checks.Cleanup; cleanup != nil {
+		setLabel(CheckCleanupLabel)
+
+		if cleanup.Code != nil {
+			labels[CleanupExitCodeLabelKey] = *cleanup.Code
+		}
+		if cleanup.After != nil {
+			labels[CleanupAfterLabelKey] = *cleanup.After
+		}
+		if cleanup.Created != nil {
+			labels[CleanupCreatedAfterLabelKey] = *cleanup.Created
+		}
+		if cleanup.Dead != nil {
+			labels[CleanupDeadAfterLabelKey] = *cleanup.Dead
+		}
+		if cleanup.Volumes != nil {
+			labels[CleanupVolumesLabelKey] = boolLabel(*cleanup.Volumes)
+		}
+		if cleanup.Links != nil {
+			labels[CleanupLinksLabelKey] = boolLabel(*cleanup.Links)
+		}
+	}
+
+	return labels
+}
+
+// boolLabel is how a label that's switched on with 1 is set
+func boolLabel(on bool) string {
+	if on {
+		return "1"
+	}
+
+	return "0"
+}
+
+// applyRules gives cont the labels of every rule matching it (later rules win), with its own labels overriding them all
+func applyRules(rules []Rule, cont types.Container) types.Container {
+	if len(rules) == 0 {
+		return cont
+	}
+
+	labels := map[string]string{}
+	for _, rule := range rules {
+		if rule.matches(cont) {
+			for k, v := range rule.labels() {
+				labels[k] = v
+			}
+		}
+	}
+
+	for k, v := range cont.Labels {
+		labels[k] = v
+	}
+
+	cont.Labels = labels
+	return cont
+}
+
+// globRegexp compiles a pattern where * matches any characters (including /), and ? any one character
+func globRegexp(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(globExpr(pattern))
+}
+
+// globExpr is the anchored regex equivalent of a glob pattern
+func globExpr(pattern string) string {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+
+	return "^" + expr + "$"
+}
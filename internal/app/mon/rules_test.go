@@ -0,0 +1,250 @@
+package mon
+
+import (
+	"context"
+	"testing"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+	"gopkg.in/yaml.v2"
+)
+
+const testRules string = `
+rules:
+  - name: web
+    match:
+      name: "web_*"
+      image: "nginx:*"
+    checks:
+      health:
+        timeout: 5s
+        max_restarts: 3
+  - name: jobs
+    match:
+      compose_project: batch
+      labels: ["com.example.kind=job"]
+    checks:
+      cleanup:
+        code: 3
+`
+
+// decodeRules decodes the rules key of a config file, as the config package does
+func decodeRules(t *testing.T, dat string) []Rule {
+	f := struct {
+		Rules []Rule `yaml:"rules"`
+	}{}
+	assert.NilError(t, yaml.UnmarshalStrict([]byte(dat), &f))
+
+	return f.Rules
+}
+
+func TestValidateRules(t *testing.T) {
+	rules := decodeRules(t, testRules)
+	assert.NilError(t, ValidateRules(rules))
+	assert.Equal(t, len(rules), 2)
+	assert.Equal(t, rules[0].Name, "web")
+	assert.Equal(t, *rules[0].Checks.Health.Timeout, "5s")
+	assert.Equal(t, rules[0].Checks.Health.Window == nil, true)
+	assert.Equal(t, rules[0].Checks.Cleanup == nil, true)
+	assert.Equal(t, *rules[1].Checks.Cleanup.Code, "3")
+
+	assert.NilError(t, ValidateRules(nil))
+
+	err := ValidateRules(decodeRules(t, "rules:\n  - checks: {health: {}}\n"))
+	assert.Error(t, err, "rule 1 (): match is empty")
+
+	err = ValidateRules(decodeRules(t, "rules:\n  - name: nothing\n    match: {name: a}\n"))
+	assert.Error(t, err, "rule 1 (nothing): no checks are enabled")
+
+	rules = decodeRules(t, "rules:\n  - match: {name: a}\n    checks: {cleanup: {code: \"0,3\"}}\n")
+	assert.NilError(t, ValidateRules(rules))
+	assert.Equal(t, *rules[0].Checks.Cleanup.Code, "0,3")
+
+	err = ValidateRules(decodeRules(t, "rules:\n  - name: typo\n    match: {name: a}\n    checks: {cleanup: {code: O}}\n"))
+	assert.Error(t, err, "rule 1 (typo): cleanup: invalid exit codes 'O'")
+
+	err = ValidateRules(decodeRules(t, "rules:\n  - name: past\n    match: {name: a}\n    checks: {cleanup: {dead_after: -1}}\n"))
+	assert.Error(t, err, "rule 1 (past): cleanup: dead_after: must not be negative, got '-1'")
+
+	rules = decodeRules(t, "rules:\n  - match: {name: a}\n    checks: {health: {timeout: 30s, window: 1h}}\n")
+	assert.NilError(t, ValidateRules(rules))
+	assert.Equal(t, rules[0].labels()[HealthRestartWindowLabelKey], "1h")
+
+	err = ValidateRules(decodeRules(t, "rules:\n  - name: slow\n    match: {name: a}\n    checks: {health: {timeout: soon}}\n"))
+	assert.Error(t, err, "rule 1 (slow): health: timeout: invalid time 'soon'")
+
+	err = ValidateRules(decodeRules(t, "rules:\n  - name: loop\n    match: {name: a}\n    checks: {health: {max_restarts: -1}}\n"))
+	assert.Error(t, err, "rule 1 (loop): health: max_restarts: must not be negative, got -1")
+
+	err = ValidateRules(decodeRules(t, "rules:\n  - name: unit\n    match: {name: a}\n    checks: {cleanup: {after: 10 minutes}}\n"))
+	assert.Error(t, err, "rule 1 (unit): cleanup: after: invalid time '10 minutes'")
+}
+
+func TestRuleCleanupLabels(t *testing.T) {
+	rules := decodeRules(t, `
+rules:
+  - match: {name: "*"}
+    checks:
+      cleanup: {after: 10m, created_after: 3600000, dead_after: 0, volumes: true, links: false}
+`)
+	assert.NilError(t, ValidateRules(rules))
+
+	// they become the labels the cleanup check reads, so they're applied the same way
+	assert.DeepEqual(t, rules[0].labels(), map[string]string{
+		"mon.observe":                      "1",
+		"mon.checks.cleanup":               "1",
+		"mon.checks.cleanup.after":         "10m",
+		"mon.checks.cleanup.created.after": "3600000",
+		"mon.checks.cleanup.dead.after":    "0",
+		"mon.checks.cleanup.volumes":       "1",
+		"mon.checks.cleanup.links":         "0",
+	})
+}
+
+func TestApplyRules(t *testing.T) {
+	rules := decodeRules(t, testRules)
+	assert.NilError(t, ValidateRules(rules))
+
+	web := types.Container{
+		Names: []string{"/web_1"},
+		Image: "nginx:1.19",
+	}
+	assert.DeepEqual(t, applyRules(rules, web).Labels, map[string]string{
+		"mon.observe":                    "1",
+		"mon.checks.health":              "1",
+		"mon.checks.health.timeout":      "5s",
+		"mon.checks.health.max-restarts": "3",
+	})
+
+	// every part of the match has to match
+	web.Image = "httpd:2"
+	assert.Equal(t, len(applyRules(rules, web).Labels), 0)
+
+	// and the container's own labels win
+	job := types.Container{
+		Names: []string{"/batch_job_1"},
+		Image: "busybox",
+		Labels: map[string]string{
+			ComposeProjectLabelKey:    "batch",
+			"com.example.kind":        "job",
+			"mon.checks.cleanup.code": "0",
+		},
+	}
+	labels := applyRules(rules, job).Labels
+	assert.Equal(t, labels[CleanupExitCodeLabelKey], "0")
+	assert.Equal(t, labelsContain(labels, CheckCleanupLabel), true)
+	assert.Equal(t, labelsContain(labels, CheckHealthLabel), false)
+
+	job.Labels["mon.observe"] = "0"
+	assert.Equal(t, labelsContain(applyRules(rules, job).Labels, ObserveLabel), false)
+}
+
+func TestGlobRegexp(t *testing.T) {
+	assert.Equal(t, globRegexp("web_*").MatchString("web_1"), true)
+	assert.Equal(t, globRegexp("web_?").MatchString("web_12"), false)
+	assert.Equal(t, globRegexp("*/nginx:*").MatchString("library/nginx:latest"), true)
+	assert.Equal(t, globRegexp("nginx").MatchString("nginx:latest"), false)
+	assert.Equal(t, globRegexp("a.b").MatchString("axb"), false)
+}
+
+func TestMonitorHandleHealthRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	rules := decodeRules(t, testRules)
+
+	// neither is labelled, but the rules match the first
+	matched := testContainers[4]
+	matched.Names = []string{"/web_1"}
+	matched.Image = "nginx:latest"
+	matched.Labels = nil
+
+	unmatched := testContainers[5]
+	unmatched.Labels = nil
+
+	// the rule's timeout is a duration, which the label takes as is
+	expected := applyRules(rules, matched)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Nil()).
+		Times(1).
+		Return([]types.Container{matched, unmatched}, nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(expected)).
+		Times(1).
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(int64(5000)), gomock.Eq(expected)).
+		Times(1).
+		Return(nil)
+
+	monitor := Monitor{
+		Dockerd: m,
+		Rules:   rules,
+	}
+
+	results, err := monitor.handleContainerHealth(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].action, RestartAction)
+}
+
+func TestMonitorHandleCleanupRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	rules := decodeRules(t, `
+rules:
+  - match: {name: "job_*"}
+    checks:
+      cleanup: {volumes: true}
+`)
+	assert.NilError(t, ValidateRules(rules))
+
+	cont := types.Container{
+		ID:    "job",
+		Names: []string{"/job_1"},
+		State: ExitedState,
+	}
+	expected := applyRules(rules, cont)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Nil()).
+		Times(1).
+		Return([]types.Container{cont}, nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(expected)).
+		Times(1).
+		Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    "job",
+				State: &types.ContainerState{Status: ExitedState},
+			},
+		}, nil)
+	m.
+		EXPECT().
+		Remove(gomock.Any(), gomock.Eq(expected), gomock.Eq(types.ContainerRemoveOptions{RemoveVolumes: true})).
+		Times(1).
+		Return(nil)
+
+	monitor := Monitor{
+		Dockerd: m,
+		Rules:   rules,
+	}
+
+	results, err := monitor.handleContainerCleanup(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].action, RemoveAction)
+}
@@ -0,0 +1,547 @@
+package mon
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ComposeServiceLabelKey is the label compose sets to the service a container belongs to
+const ComposeServiceLabelKey string = "com.docker.compose.service"
+
+// Selector chooses which containers mon observes - every part that's set must match. Patterns are globs (where * and
+// ? match any characters), or regexes when wrapped in slashes, like "/^web-[0-9]+$/"
+type Selector struct {
+	// Include are patterns for container names, at least one of which must match
+	Include []string `yaml:"include"`
+	// Exclude are patterns for container names, none of which may match
+	Exclude []string `yaml:"exclude"`
+	// Images are patterns for the image a container was created from, at least one of which must match. A pattern
+	// without a tag (like "nginx") matches every tag, and docker.io/library/ can be left off
+	Images []string `yaml:"images"`
+	// ComposeProjects are patterns for the compose project a container belongs to, at least one of which must match
+	ComposeProjects []string `yaml:"compose_projects"`
+	// ComposeServices are patterns for the compose service a container belongs to, at least one of which must match
+	ComposeServices []string `yaml:"compose_services"`
+	// Labels is an expression over the container's labels, like "team=payments && env!=dev"
+	Labels string `yaml:"labels"`
+
+	// the patterns and label expression are compiled on first use, so they aren't for every container
+	once     sync.Once
+	compiled *compiledSelector
+	err      error
+}
+
+// compiledSelector is a Selector's patterns and label expression, ready to match against
+type compiledSelector struct {
+	include         []*regexp.Regexp
+	exclude         []*regexp.Regexp
+	images          []*regexp.Regexp
+	composeProjects []*regexp.Regexp
+	composeServices []*regexp.Regexp
+	labels          labelExpr
+}
+
+// compile compiles the selector the first time it's called, returning the same result every time after
+func (s *Selector) compile() (*compiledSelector, error) {
+	s.once.Do(func() {
+		c := &compiledSelector{}
+
+		for _, field := range []struct {
+			name     string
+			patterns []string
+			compiled *[]*regexp.Regexp
+			compile  func(string) (*regexp.Regexp, error)
+		}{
+			{"include", s.Include, &c.include, compilePattern},
+			{"exclude", s.Exclude, &c.exclude, compilePattern},
+			{"images", s.Images, &c.images, compileImagePattern},
+			{"compose_projects", s.ComposeProjects, &c.composeProjects, compilePattern},
+			{"compose_services", s.ComposeServices, &c.composeServices, compilePattern},
+		} {
+			for _, pattern := range field.patterns {
+				re, err := field.compile(pattern)
+				if err != nil {
+					s.err = fmt.Errorf("%s: invalid pattern '%s': %v", field.name, pattern, err)
+					return
+				}
+
+				*field.compiled = append(*field.compiled, re)
+			}
+		}
+
+		expr, err := parseLabelExpr(s.Labels)
+		if err != nil {
+			s.err = fmt.Errorf("labels: invalid expression '%s': %v", s.Labels, err)
+			return
+		}
+		c.labels = expr
+
+		s.compiled = c
+	})
+
+	return s.compiled, s.err
+}
+
+// Validate checks every pattern and the label expression parse
+func (s *Selector) Validate() error {
+	if s == nil {
+		return nil
+	}
+
+	_, err := s.compile()
+	return err
+}
+
+// Matches checks if cont is selected, a nil Selector selects everything
+func (s *Selector) Matches(cont types.Container) bool {
+	if s == nil {
+		return true
+	}
+
+	var names []string
+	for _, name := range cont.Names {
+		names = append(names, strings.TrimPrefix(name, "/"))
+	}
+
+	c, err := s.compile()
+	if err != nil {
+		// Validate should have caught it, but we'd rather observe nothing than the wrong thing
+		return false
+	}
+
+	if len(c.include) > 0 && !anyRegexpMatches(c.include, names...) {
+		return false
+	}
+
+	if anyRegexpMatches(c.exclude, names...) {
+		return false
+	}
+
+	if len(c.images) > 0 && !anyImageMatches(c.images, cont.Image) {
+		return false
+	}
+
+	if len(c.composeProjects) > 0 && !anyRegexpMatches(c.composeProjects, cont.Labels[ComposeProjectLabelKey]) {
+		return false
+	}
+
+	if len(c.composeServices) > 0 && !anyRegexpMatches(c.composeServices, cont.Labels[ComposeServiceLabelKey]) {
+		return false
+	}
+
+	return c.labels.eval(cont.Labels)
+}
+
+// Filters are the label filters docker can apply for us, a subset of what Matches checks
+func (s *Selector) Filters() []string {
+	if s == nil {
+		return nil
+	}
+
+	var filters []string
+
+	// only a single plain value can be pushed down, the list filter can't "or" labels or match patterns
+	if len(s.ComposeProjects) == 1 && isPlainPattern(s.ComposeProjects[0]) {
+		filters = append(filters, ComposeProjectLabelKey+"="+s.ComposeProjects[0])
+	}
+	if len(s.ComposeServices) == 1 && isPlainPattern(s.ComposeServices[0]) {
+		filters = append(filters, ComposeServiceLabelKey+"="+s.ComposeServices[0])
+	}
+
+	if c, err := s.compile(); err == nil {
+		filters = append(filters, labelFilters(c.labels)...)
+	}
+
+	return filters
+}
+
+// String describes the selector for logging, empty if it selects everything
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, field := range []struct {
+		name     string
+		patterns []string
+	}{
+		{"include", s.Include},
+		{"exclude", s.Exclude},
+		{"images", s.Images},
+		{"compose_projects", s.ComposeProjects},
+		{"compose_services", s.ComposeServices},
+	} {
+		if len(field.patterns) > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%s", field.name, strings.Join(field.patterns, ",")))
+		}
+	}
+
+	if len(strings.TrimSpace(s.Labels)) > 0 {
+		parts = append(parts, fmt.Sprintf("labels=%q", s.Labels))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// compilePattern compiles a glob, or a regex wrapped in slashes (which isn't anchored, unless it anchors itself)
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if isRegexPattern(pattern) {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+
+	return regexp.Compile(globExpr(pattern))
+}
+
+func isRegexPattern(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// isPlainPattern checks if pattern only matches itself
+func isPlainPattern(pattern string) bool {
+	return !isRegexPattern(pattern) && !strings.ContainsAny(pattern, "*?")
+}
+
+// compileImagePattern compiles an image pattern, shortened the way image references are matched (see familiarImage)
+func compileImagePattern(pattern string) (*regexp.Regexp, error) {
+	if !isRegexPattern(pattern) {
+		pattern = familiarImage(pattern)
+	}
+
+	return compilePattern(pattern)
+}
+
+// anyRegexpMatches checks if any of res matches any of values
+func anyRegexpMatches(res []*regexp.Regexp, values ...string) bool {
+	for _, re := range res {
+		for _, val := range values {
+			if re.MatchString(val) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// anyImageMatches checks if any of the compiled image patterns matches image, by its full reference or just its
+// repository
+func anyImageMatches(res []*regexp.Regexp, image string) bool {
+	image = familiarImage(image)
+	return anyRegexpMatches(res, image, imageRepo(image))
+}
+
+// imageRepo is the repository of an image reference, without its tag or digest
+func imageRepo(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return image
+}
+
+// familiarImage shortens an image reference the way the docker cli shows it, so "docker.io/library/nginx" is "nginx"
+func familiarImage(image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	return strings.TrimPrefix(image, "library/")
+}
+
+// labelExpr is a parsed label expression
+type labelExpr interface {
+	eval(labels map[string]string) bool
+}
+
+// labelTerm is "key" (the label is set), "key=value" or "key!=value" (the label isn't set, or is set to something
+// else) - values can be globs
+type labelTerm struct {
+	key      string
+	value    string
+	hasValue bool
+	negate   bool
+	// glob is value, compiled
+	glob *regexp.Regexp
+}
+
+func (t labelTerm) eval(labels map[string]string) bool {
+	val, ok := labels[t.key]
+
+	matched := ok
+	if t.hasValue {
+		matched = ok && t.glob.MatchString(val)
+	}
+
+	return matched != t.negate
+}
+
+type labelNot struct {
+	expr labelExpr
+}
+
+func (n labelNot) eval(labels map[string]string) bool {
+	return !n.expr.eval(labels)
+}
+
+type labelAnd []labelExpr
+
+func (a labelAnd) eval(labels map[string]string) bool {
+	for _, expr := range a {
+		if !expr.eval(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type labelOr []labelExpr
+
+func (o labelOr) eval(labels map[string]string) bool {
+	for _, expr := range o {
+		if expr.eval(labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// labelFilters are the list filters equivalent to the parts of expr every selected container must satisfy
+func labelFilters(expr labelExpr) []string {
+	switch e := expr.(type) {
+	case labelTerm:
+		if e.negate || strings.ContainsAny(e.value, "*?") {
+			return nil
+		}
+		if e.hasValue {
+			return []string{e.key + "=" + e.value}
+		}
+		return []string{e.key}
+	case labelAnd:
+		var filters []string
+		for _, child := range e {
+			filters = append(filters, labelFilters(child)...)
+		}
+		return filters
+	}
+
+	return nil
+}
+
+// parseLabelExpr parses an expression of terms (key, key=value, key!=value), combined with &&, ||, ! and
+// parentheses. && binds tighter than ||, and an empty expression matches everything
+func parseLabelExpr(s string) (labelExpr, error) {
+	tokens, err := tokenizeLabelExpr(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return labelAnd{}, nil
+	}
+
+	p := &labelParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected '%s'", p.peek())
+	}
+
+	return expr, nil
+}
+
+// labelOperators are the tokens that aren't keys or values, longest first
+var labelOperators = []string{"&&", "||", "!=", "=", "!", "(", ")"}
+
+func tokenizeLabelExpr(s string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(s); {
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+
+		if s[i] == '"' {
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			// quoted values are kept quoted, so they're never mistaken for an operator
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+			continue
+		}
+
+		operator := ""
+		for _, op := range labelOperators {
+			if strings.HasPrefix(s[i:], op) {
+				operator = op
+				break
+			}
+		}
+		if len(operator) > 0 {
+			tokens = append(tokens, operator)
+			i += len(operator)
+			continue
+		}
+
+		end := i
+		for end < len(s) && !strings.ContainsRune(" \t\"&|!=()", rune(s[end])) {
+			end++
+		}
+		if end == i {
+			return nil, fmt.Errorf("unexpected '%c'", s[i])
+		}
+
+		tokens = append(tokens, s[i:end])
+		i = end
+	}
+
+	return tokens, nil
+}
+
+type labelParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *labelParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *labelParser) peek() string {
+	if p.done() {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *labelParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *labelParser) or() (labelExpr, error) {
+	exprs := labelOr{}
+
+	for {
+		expr, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if p.peek() != "||" {
+			break
+		}
+		p.next()
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+
+	return exprs, nil
+}
+
+func (p *labelParser) and() (labelExpr, error) {
+	exprs := labelAnd{}
+
+	for {
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if p.peek() != "&&" {
+			break
+		}
+		p.next()
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+
+	return exprs, nil
+}
+
+func (p *labelParser) unary() (labelExpr, error) {
+	switch tok := p.next(); tok {
+	case "!":
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return labelNot{expr: expr}, nil
+	case "(":
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return expr, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		if !isLabelWord(tok) {
+			return nil, fmt.Errorf("expected a label, got '%s'", tok)
+		}
+
+		term := labelTerm{key: unquote(tok)}
+
+		if op := p.peek(); op == "=" || op == "!=" {
+			p.next()
+
+			val := p.next()
+			if !isLabelWord(val) {
+				return nil, fmt.Errorf("expected a value after '%s%s'", term.key, op)
+			}
+
+			term.value = unquote(val)
+			term.glob = globRegexp(term.value)
+			term.hasValue = true
+			term.negate = op == "!="
+		}
+
+		return term, nil
+	}
+}
+
+// isLabelWord checks tok is a key or value, rather than an operator
+func isLabelWord(tok string) bool {
+	if len(tok) == 0 {
+		return false
+	}
+
+	for _, op := range labelOperators {
+		if tok == op {
+			return false
+		}
+	}
+
+	return true
+}
+
+func unquote(tok string) string {
+	if len(tok) >= 2 && strings.HasPrefix(tok, `"`) && strings.HasSuffix(tok, `"`) {
+		return tok[1 : len(tok)-1]
+	}
+
+	return tok
+}
@@ -0,0 +1,177 @@
+package mon
+
+import (
+	"context"
+	"testing"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+)
+
+func TestSelectorNames(t *testing.T) {
+	selector := &Selector{
+		Include: []string{"web_*", "/^api-[0-9]+$/"},
+		Exclude: []string{"*_debug"},
+	}
+	assert.NilError(t, selector.Validate())
+
+	assert.Equal(t, selector.Matches(types.Container{Names: []string{"/web_1"}}), true)
+	assert.Equal(t, selector.Matches(types.Container{Names: []string{"/api-12"}}), true)
+	assert.Equal(t, selector.Matches(types.Container{Names: []string{"/api-12b"}}), false)
+	assert.Equal(t, selector.Matches(types.Container{Names: []string{"/web_1_debug"}}), false)
+	assert.Equal(t, selector.Matches(types.Container{Names: []string{"/db"}}), false)
+
+	// nil selects everything
+	var none *Selector
+	assert.Equal(t, none.Matches(types.Container{Names: []string{"/db"}}), true)
+	assert.NilError(t, none.Validate())
+}
+
+func TestSelectorImages(t *testing.T) {
+	selector := &Selector{Images: []string{"nginx", "docker.io/library/redis:6*", "/^ghcr.io/acme//"}}
+	assert.NilError(t, selector.Validate())
+
+	assert.Equal(t, selector.Matches(types.Container{Image: "nginx"}), true)
+	assert.Equal(t, selector.Matches(types.Container{Image: "nginx:1.19"}), true)
+	assert.Equal(t, selector.Matches(types.Container{Image: "docker.io/library/nginx:1.19"}), true)
+	assert.Equal(t, selector.Matches(types.Container{Image: "nginx@sha256:abc"}), true)
+	assert.Equal(t, selector.Matches(types.Container{Image: "redis:6.0"}), true)
+	assert.Equal(t, selector.Matches(types.Container{Image: "redis:5.0"}), false)
+	assert.Equal(t, selector.Matches(types.Container{Image: "ghcr.io/acme/app:1"}), true)
+	assert.Equal(t, selector.Matches(types.Container{Image: "localhost:5000/nginx"}), false)
+}
+
+func TestSelectorCompose(t *testing.T) {
+	selector := &Selector{
+		ComposeProjects: []string{"shop"},
+		ComposeServices: []string{"web", "worker-*"},
+	}
+	assert.NilError(t, selector.Validate())
+
+	assert.Equal(t, selector.Matches(types.Container{Labels: map[string]string{
+		ComposeProjectLabelKey: "shop",
+		ComposeServiceLabelKey: "worker-1",
+	}}), true)
+	assert.Equal(t, selector.Matches(types.Container{Labels: map[string]string{
+		ComposeProjectLabelKey: "blog",
+		ComposeServiceLabelKey: "web",
+	}}), false)
+	assert.Equal(t, selector.Matches(types.Container{}), false)
+
+	// the services can't be pushed down, as list filters on the same label must all match
+	assert.EqualStringSlice(t, selector.Filters(), []string{ComposeProjectLabelKey + "=shop"})
+}
+
+func TestSelectorLabels(t *testing.T) {
+	for expr, cases := range map[string]map[bool][]map[string]string{
+		"team=payments && env!=dev": {
+			true:  {{"team": "payments"}, {"team": "payments", "env": "prod"}},
+			false: {{"team": "payments", "env": "dev"}, {"team": "search"}, {}},
+		},
+		"team=payments || team=search": {
+			true:  {{"team": "payments"}, {"team": "search"}},
+			false: {{"team": "ads"}, {}},
+		},
+		"!(tier=db || critical) && team": {
+			true:  {{"team": "a"}, {"team": "a", "tier": "web"}},
+			false: {{"team": "a", "tier": "db"}, {"team": "a", "critical": "1"}, {"tier": "web"}},
+		},
+		`owner="some one" && env=prod-*`: {
+			true:  {{"owner": "some one", "env": "prod-eu"}},
+			false: {{"owner": "some", "env": "prod-eu"}, {"owner": "some one", "env": "dev"}},
+		},
+		"": {
+			true: {{}, {"team": "a"}},
+		},
+	} {
+		selector := &Selector{Labels: expr}
+		assert.NilError(t, selector.Validate())
+
+		for want, labelSets := range cases {
+			for _, labels := range labelSets {
+				if got := selector.Matches(types.Container{Labels: labels}); got != want {
+					t.Errorf("%q with labels %v: got %t, want %t", expr, labels, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSelectorFilters(t *testing.T) {
+	for expr, filters := range map[string][]string{
+		"team=payments && env!=dev":      {"team=payments"},
+		"team && (a=1 && b=2)":           {"team", "a=1", "b=2"},
+		"team=payments || team=search":   nil,
+		"!team && env=prod-*":            nil,
+		"team=payments && !(env=dev)":    {"team=payments"},
+		`team="payments" && region=eu-1`: {"team=payments", "region=eu-1"},
+	} {
+		assert.EqualStringSlice(t, (&Selector{Labels: expr}).Filters(), filters)
+	}
+}
+
+func TestSelectorValidate(t *testing.T) {
+	assert.Error(t, (&Selector{Include: []string{"/web[/"}}).Validate(), "include: invalid pattern '/web[/'")
+	assert.Error(t, (&Selector{Labels: "team=payments &&"}).Validate(), "unexpected end of expression")
+	assert.Error(t, (&Selector{Labels: "(team=payments"}).Validate(), "missing ')'")
+	assert.Error(t, (&Selector{Labels: "team=payments env=dev"}).Validate(), "unexpected 'env'")
+	assert.Error(t, (&Selector{Labels: "team=&&"}).Validate(), "expected a value after 'team='")
+	assert.Error(t, (&Selector{Labels: `team="payments`}).Validate(), "unterminated quote")
+}
+
+func TestSelectorCompilesOnce(t *testing.T) {
+	selector := &Selector{Include: []string{"web_*"}, Labels: "team=pay*"}
+
+	compiled, err := selector.compile()
+	assert.NilError(t, err)
+
+	again, err := selector.compile()
+	assert.NilError(t, err)
+	assert.Equal(t, again == compiled, true)
+
+	// an invalid selector is only compiled (and fails) once too, matching nothing
+	invalid := &Selector{Include: []string{"/web[/"}}
+	assert.Equal(t, invalid.Matches(testContainers[0]), false)
+	assert.Error(t, invalid.Validate(), "include: invalid pattern '/web[/'")
+}
+
+func TestMonitorSelectorPushdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckCleanupLabel,
+			"team=payments",
+		})).
+		Times(1).
+		Return([]types.Container{
+			{
+				ID:     "abc",
+				Names:  []string{"/pay_1"},
+				Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "team": "payments"},
+			},
+			{
+				ID:     "def",
+				Names:  []string{"/pay_2"},
+				Labels: map[string]string{"mon.observe": "1", "mon.checks.cleanup": "1", "team": "payments", "env": "dev"},
+			},
+		}, nil)
+
+	monitor := Monitor{
+		Selector: &Selector{Labels: "team=payments && env!=dev"},
+		Dockerd:  m,
+	}
+
+	// env!=dev can't be pushed down, so it's checked here
+	conts, err := monitor.listContainers(context.Background(), CheckCleanupLabel)
+	assert.NilError(t, err)
+	assert.Equal(t, len(conts), 1)
+	assert.Equal(t, conts[0].ID, "abc")
+}
@@ -0,0 +1,118 @@
+package mon
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ResultOK is the result of a check that completed, whether or not it acted
+const ResultOK string = "ok"
+
+// ResultBusy is the result of a check skipped because the container was already being acted on
+const ResultBusy string = "busy"
+
+// ResultError is the result of a check that failed
+const ResultError string = "error"
+
+// CheckStatus is the outcome of one check of a container, with Planned set if its action only happened in a dry run
+type CheckStatus struct {
+	Check   string    `json:"check"`
+	Result  string    `json:"result"`
+	Action  string    `json:"action,omitempty"`
+	Planned bool      `json:"planned,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// ContainerStatus is what the monitor knows about a container it observes
+type ContainerStatus struct {
+	Host       string       `json:"host,omitempty"`
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	State      string       `json:"state"`
+	LastCheck  *CheckStatus `json:"last_check,omitempty"`
+	LastAction *CheckStatus `json:"last_action,omitempty"`
+}
+
+// Containers returns the containers observed by the last poll (or events since), sorted by name
+func (m *Monitor) Containers() []ContainerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]ContainerStatus, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		res = append(res, *status)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res
+}
+
+// recorded wraps a check so its outcome is kept for Containers
+func (m *Monitor) recorded(check string, fn func(context.Context, types.Container) checkResult) func(context.Context, types.Container) checkResult {
+	return func(ctx context.Context, cont types.Container) checkResult {
+		res := fn(ctx, cont)
+		if res.checked {
+			m.recordCheck(check, cont, res)
+		}
+
+		return res
+	}
+}
+
+func (m *Monitor) recordCheck(check string, cont types.Container, res checkResult) {
+	checkStatus := &CheckStatus{
+		Check:   check,
+		Result:  ResultOK,
+		Action:  res.action,
+		Planned: res.planned,
+		Time:    m.now(),
+	}
+
+	switch {
+	case res.err != nil:
+		checkStatus.Result = ResultError
+		checkStatus.Error = res.err.Error()
+	case res.busy:
+		checkStatus.Result = ResultBusy
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.statuses == nil {
+		m.statuses = map[string]*ContainerStatus{}
+	}
+
+	status, ok := m.statuses[cont.ID]
+	if !ok {
+		status = &ContainerStatus{Host: m.Host, ID: cont.ID}
+		m.statuses[cont.ID] = status
+	}
+
+	status.Name = strings.TrimPrefix(cont.Names[0], "/")
+	status.State = cont.State
+	status.LastCheck = checkStatus
+	if len(res.action) > 0 {
+		status.LastAction = checkStatus
+	}
+}
+
+// pruneStatuses forgets containers that weren't checked by the last poll
+func (m *Monitor) pruneStatuses(seen map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range m.statuses {
+		if !seen[id] {
+			delete(m.statuses, id)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mon
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// TLSOptions locate the cert material for talking to a daemon started with --tlsverify
+type TLSOptions struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// Verify checks the daemon's certificate (against CAFile, or the system roots without one)
+	Verify bool
+}
+
+// TLSOptionsFromCertPath is the cert material the docker cli would use for DOCKER_CERT_PATH and DOCKER_TLS_VERIFY
+func TLSOptionsFromCertPath(certPath string, verify bool) TLSOptions {
+	return TLSOptions{
+		CAFile:   filepath.Join(certPath, "ca.pem"),
+		CertFile: filepath.Join(certPath, "cert.pem"),
+		KeyFile:  filepath.Join(certPath, "key.pem"),
+		Verify:   verify,
+	}
+}
+
+// Enabled is true if any cert material is given
+func (o TLSOptions) Enabled() bool {
+	return len(o.CAFile) > 0 || len(o.CertFile) > 0 || len(o.KeyFile) > 0
+}
+
+// Config loads the cert material, failing if any of it can't be read or doesn't fit together
+func (o TLSOptions) Config() (*tls.Config, error) {
+	if (len(o.CertFile) > 0) != (len(o.KeyFile) > 0) {
+		return nil, errors.New("Invalid TLS cert material: a client certificate and key must be given together")
+	}
+
+	config, err := tlsconfig.Client(tlsconfig.Options{
+		CAFile:             o.CAFile,
+		CertFile:           o.CertFile,
+		KeyFile:            o.KeyFile,
+		InsecureSkipVerify: !o.Verify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS cert material: %v", err)
+	}
+
+	return config, nil
+}
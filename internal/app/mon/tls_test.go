@@ -0,0 +1,140 @@
+package mon
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// writeClientCert writes a self-signed client cert.pem and key.pem to dir, returning the cert
+func writeClientCert(t *testing.T, dir string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mon"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+		// a self-signed cert has to be a CA to verify itself
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NilError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NilError(t, err)
+
+	return cert
+}
+
+func TestDockerDTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mon")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	clientCert := writeClientCert(t, dir)
+
+	// like a daemon started with --tlsverify, only clients with a cert it trusts get in
+	daemon := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(testContainers)
+	}))
+	daemon.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	daemon.TLS.ClientCAs.AddCert(clientCert)
+	daemon.StartTLS()
+	defer daemon.Close()
+
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: daemon.Certificate().Raw}), 0600))
+
+	config, err := TLSOptionsFromCertPath(dir, true).Config()
+	assert.NilError(t, err)
+
+	dockerd := DockerD{
+		ControlAddr:   "tcp://" + daemon.Listener.Addr().String(),
+		TargetVersion: "1.37",
+		TLS:           config,
+		Retry:         RetryPolicy{MaxAttempts: 1},
+	}
+	defer dockerd.Close()
+
+	conts, err := dockerd.ExecuteListQuery(context.Background(), nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(conts), len(testContainers))
+
+	// without the client cert, the daemon turns us away
+	config, err = TLSOptions{CAFile: filepath.Join(dir, "ca.pem"), Verify: true}.Config()
+	assert.NilError(t, err)
+
+	anonymous := DockerD{
+		ControlAddr:   "tcp://" + daemon.Listener.Addr().String(),
+		TargetVersion: "1.37",
+		TLS:           config,
+		Retry:         RetryPolicy{MaxAttempts: 1},
+	}
+	defer anonymous.Close()
+
+	_, err = anonymous.ExecuteListQuery(context.Background(), nil)
+	assert.NotNil(t, err)
+
+	// and without the CA, we turn the daemon away
+	config, err = TLSOptions{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), Verify: true}.Config()
+	assert.NilError(t, err)
+
+	untrusting := DockerD{
+		ControlAddr:   "tcp://" + daemon.Listener.Addr().String(),
+		TargetVersion: "1.37",
+		TLS:           config,
+		Retry:         RetryPolicy{MaxAttempts: 1},
+	}
+	defer untrusting.Close()
+
+	_, err = untrusting.ExecuteListQuery(context.Background(), nil)
+	assert.Error(t, err, "certificate")
+}
+
+func TestTLSOptionsInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mon")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = TLSOptionsFromCertPath(dir, true).Config()
+	assert.Error(t, err, "Invalid TLS cert material: could not read CA certificate")
+
+	_, err = TLSOptions{CertFile: filepath.Join(dir, "cert.pem")}.Config()
+	assert.Error(t, err, "a client certificate and key must be given together")
+
+	writeClientCert(t, dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "key.pem"), []byte("not a key"), 0600))
+
+	_, err = TLSOptions{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}.Config()
+	assert.Error(t, err, "Invalid TLS cert material: Could not load X509 key pair")
+}
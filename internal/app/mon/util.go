@@ -1,51 +1,145 @@
 package mon
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/client"
 )
 
-func namesContainPrefix(names []string, prefix string) bool {
-	for _, name := range names {
-		if strings.HasPrefix(name, prefix) {
-			return true
+// labelsContain checks labels for a "key=value" pair, as used in list filters
+func labelsContain(labels map[string]string, pair string) bool {
+	parts := strings.SplitN(pair, "=", 2)
+	val, ok := labels[parts[0]]
+
+	if len(parts) == 1 {
+		return ok
+	}
+
+	return ok && val == parts[1]
+}
+
+// eventAttributes are the container event attributes that describe the event, rather than being one of its labels
+var eventAttributes = map[string]bool{"name": true, "image": true, "exitCode": true, "signal": true}
+
+// eventToContainer builds the container an event refers to - container event attributes carry its name, image and labels
+func eventToContainer(msg events.Message) types.Container {
+	// the labels have to be the same as a poll would see, for the rules and selector to match the same way
+	labels := map[string]string{}
+	for k, v := range msg.Actor.Attributes {
+		if !eventAttributes[k] {
+			labels[k] = v
 		}
 	}
 
-	return false
+	return types.Container{
+		ID:     msg.Actor.ID,
+		Names:  []string{"/" + msg.Actor.Attributes["name"]},
+		Image:  msg.Actor.Attributes["image"],
+		Labels: labels,
+		Status: msg.Action,
+	}
 }
 
-func withRetry(max int64, fn func() error) error {
-	for i := int64(0); i < max; i++ {
-		err := fn()
+// anonymousVolumeName is how docker names a volume it created for a container, rather than one named by the user
+var anonymousVolumeName = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// linkNames finds the names other containers have linked to cont by, like "/parent/alias"
+func linkNames(cont types.Container) []string {
+	var links []string
+	for _, name := range cont.Names {
+		if strings.Contains(strings.TrimPrefix(name, "/"), "/") {
+			links = append(links, name)
+		}
+	}
+
+	return links
+}
 
-		if err == nil {
-			return nil
+// anonymousVolumes finds the names of the volumes docker created for the container, which removing it with its
+// volumes reclaims - named volumes are kept either way
+func anonymousVolumes(inspect types.ContainerJSON) []string {
+	var volumes []string
+	for _, mount := range inspect.Mounts {
+		if mount.Type == "volume" && anonymousVolumeName.MatchString(mount.Name) {
+			volumes = append(volumes, mount.Name)
 		}
+	}
+
+	return volumes
+}
+
+// isTransportError checks if err came from the connection to the daemon, rather than from the daemon itself
+func isTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if client.IsErrConnectionFailed(err) {
+		return true
+	}
 
-		log.Printf("Error %v\n", err)
-		log.Printf("Retrying (attempt %v/%v)...\n", i, max)
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
 	}
 
-	return errors.New("Retry count exceeded")
+	// the client wraps any other failure to send a request this way
+	return strings.Contains(err.Error(), "error during connect")
+}
+
+// isNotFound checks if err is the daemon saying something doesn't exist, which it doesn't type for most operations
+func isNotFound(err error) bool {
+	return client.IsErrNotFound(err) || strings.Contains(strings.ToLower(err.Error()), "no such")
+}
+
+// isContextError checks if err is from a cancelled, or timed out, context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
 
-func withCli(controlAddr string, targetVer string, fn func(*client.Client) error) error {
-	cli, err := client.NewClient(controlAddr, targetVer, nil, nil)
+// parseMs parses a label's ms value, which can be given with a unit (e.g. "10m") instead
+func parseMs(raw string) (int64, error) {
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return i, nil
+	}
+
+	d, err := time.ParseDuration(raw)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	return int64(d / time.Millisecond), nil
+}
+
+// labelMs reads a label's ms value (see parseMs), using def when it isn't set - but failing when it can't be parsed
+func labelMs(labels map[string]string, key string, def int64) (int64, error) {
+	raw, ok := labels[key]
+	if !ok {
+		return def, nil
 	}
 
-	if err := fn(cli); err != nil {
-		return err
+	ms, err := parseMs(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s label %q: %w", key, raw, err)
 	}
 
-	if err := cli.Close(); err != nil {
-		return err
+	return ms, nil
+}
+
+// msOrDefault converts a ms value to a duration, using def when it isn't set
+func msOrDefault(ms int64, def int64) time.Duration {
+	if ms <= 0 {
+		ms = def
 	}
 
-	return nil
+	return time.Duration(ms) * time.Millisecond
 }
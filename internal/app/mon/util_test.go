@@ -3,47 +3,85 @@ package mon
 import (
 	"encoding/json"
 	"errors"
+	"net"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/testutil/assert"
 )
 
-func TestNamesContainPrefix(t *testing.T) {
-	assert.Equal(t, namesContainPrefix([]string{"a_one"}, "a"), true)
-	assert.Equal(t, namesContainPrefix([]string{"a_one", "a_two"}, "a"), true)
-	assert.Equal(t, namesContainPrefix([]string{"a_one", "two"}, "a"), true)
-	assert.Equal(t, namesContainPrefix([]string{"a_one", "a_two"}, "b"), false)
-	assert.Equal(t, namesContainPrefix([]string{"a_one"}, "b"), false)
+func serializeState(state types.ContainerState) string {
+	dat, err := json.Marshal(state)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(dat)
 }
 
-func TestWithRetryOk(t *testing.T) {
-	count := 0
-	err := withRetry(5, func() error {
-		count++
-		return nil
-	})
+func TestLabelsContain(t *testing.T) {
+	labels := map[string]string{"mon.observe": "1", "mon.checks.health": "0"}
 
-	assert.NilError(t, err)
-	assert.Equal(t, count, 1)
+	assert.Equal(t, labelsContain(labels, "mon.observe=1"), true)
+	assert.Equal(t, labelsContain(labels, "mon.observe"), true)
+	assert.Equal(t, labelsContain(labels, "mon.checks.health=1"), false)
+	assert.Equal(t, labelsContain(labels, "mon.checks.cleanup"), false)
 }
 
-func TestWithRetryErr(t *testing.T) {
-	count := 0
-	err := withRetry(5, func() error {
-		count++
-		return errors.New("test fail")
+func TestEventToContainer(t *testing.T) {
+	cont := eventToContainer(events.Message{
+		Type:   events.ContainerEventType,
+		Action: DieEvent,
+		Actor: events.Actor{
+			ID:         "abc",
+			Attributes: map[string]string{"name": "web", "image": "nginx", "exitCode": "0", "signal": "15", "mon.observe": "1"},
+		},
 	})
 
-	assert.NotNil(t, err)
-	assert.Equal(t, count, 5)
+	assert.Equal(t, cont.Names[0], "/web")
+	assert.Equal(t, cont.Image, "nginx")
+	// only the container's own labels, as a poll would see them
+	assert.DeepEqual(t, cont.Labels, map[string]string{"mon.observe": "1"})
 }
 
-func serializeState(state types.ContainerState) string {
-	dat, err := json.Marshal(state)
-	if err != nil {
-		panic(err)
+func TestIsTransportError(t *testing.T) {
+	assert.Equal(t, isTransportError(nil), false)
+	assert.Equal(t, isTransportError(errors.New("Error response from daemon: No such container: abc")), false)
+	assert.Equal(t, isTransportError(client.ErrorConnectionFailed("unix:///var/run/docker.sock")), true)
+	assert.Equal(t, isTransportError(&net.OpError{Op: "dial", Err: errors.New("connection reset")}), true)
+}
+
+func TestLinkNames(t *testing.T) {
+	assert.EqualStringSlice(t, linkNames(types.Container{Names: []string{"/db", "/web/db", "/worker/database"}}), []string{"/web/db", "/worker/database"})
+	assert.Equal(t, len(linkNames(types.Container{Names: []string{"/db"}})), 0)
+}
+
+func TestAnonymousVolumes(t *testing.T) {
+	anonymous := strings.Repeat("ab", 32)
+
+	inspect := types.ContainerJSON{
+		Mounts: []types.MountPoint{
+			{Type: "volume", Name: anonymous, Destination: "/data"},
+			{Type: "volume", Name: "pgdata", Destination: "/var/lib/postgresql/data"},
+			{Type: "bind", Source: "/etc/app", Destination: "/etc/app"},
+		},
 	}
 
-	return string(dat)
+	assert.EqualStringSlice(t, anonymousVolumes(inspect), []string{anonymous})
+}
+
+func TestParseMs(t *testing.T) {
+	ms, err := parseMs("1500")
+	assert.NilError(t, err)
+	assert.Equal(t, ms, int64(1500))
+
+	ms, err = parseMs("10m")
+	assert.NilError(t, err)
+	assert.Equal(t, ms, int64(10*60*1000))
+
+	_, err = parseMs("soon")
+	assert.NotNil(t, err)
 }
@@ -0,0 +1,54 @@
+package mon
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/versions"
+)
+
+// MaxAPIVersion is the newest docker API version mon negotiates, see https://docs.docker.com/engine/api/#api-version-matrix
+const MaxAPIVersion string = "1.41"
+
+// FallbackAPIVersion is assumed of a daemon that doesn't report its API version, as the docker cli does
+const FallbackAPIVersion string = "1.24"
+
+// HealthFilterAPIVersion is the first docker API version that can list containers by their health
+const HealthFilterAPIVersion string = "1.24"
+
+// DefaultPingTimeoutMs is the default timeout for pinging the daemon to negotiate an API version
+const DefaultPingTimeoutMs int64 = 10 * 1000
+
+// APIVersioner is implemented by DockerAPIs that know which docker API version they speak
+type APIVersioner interface {
+	APIVersion(ctx context.Context) (string, error)
+}
+
+// APIFeatures are the parts of the docker API that are only used when the daemon supports them
+type APIFeatures struct {
+	// HealthFilter lists unhealthy containers, so healthy ones needn't be inspected every poll
+	HealthFilter bool
+}
+
+// FeaturesFor finds the features available at an API version, an unknown (empty) version has none of them
+func FeaturesFor(version string) APIFeatures {
+	if len(version) == 0 {
+		return APIFeatures{}
+	}
+
+	return APIFeatures{
+		HealthFilter: versions.GreaterThanOrEqualTo(version, HealthFilterAPIVersion),
+	}
+}
+
+// negotiateVersion picks the newest API version both we and the daemon speak
+func negotiateVersion(daemonVersion string) string {
+	if len(daemonVersion) == 0 {
+		return FallbackAPIVersion
+	}
+
+	if versions.LessThan(daemonVersion, MaxAPIVersion) {
+		return daemonVersion
+	}
+
+	return MaxAPIVersion
+}
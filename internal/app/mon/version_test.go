@@ -0,0 +1,204 @@
+package mon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+)
+
+// versionedDockerAPI is a mock DockerAPI that knows its API version
+type versionedDockerAPI struct {
+	*mocks.MockDockerAPI
+	version string
+}
+
+func (v *versionedDockerAPI) APIVersion(ctx context.Context) (string, error) {
+	return v.version, nil
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	assert.Equal(t, negotiateVersion("1.30"), "1.30")
+	assert.Equal(t, negotiateVersion(MaxAPIVersion), MaxAPIVersion)
+	assert.Equal(t, negotiateVersion("1.45"), MaxAPIVersion)
+	assert.Equal(t, negotiateVersion(""), FallbackAPIVersion)
+}
+
+func TestFeaturesFor(t *testing.T) {
+	assert.Equal(t, FeaturesFor("1.23").HealthFilter, false)
+	assert.Equal(t, FeaturesFor("1.24").HealthFilter, true)
+	assert.Equal(t, FeaturesFor("1.41").HealthFilter, true)
+	assert.Equal(t, FeaturesFor("").HealthFilter, false)
+}
+
+func TestDockerDNegotiatesVersion(t *testing.T) {
+	for daemonVersion, want := range map[string]string{
+		"1.30": "1.30",
+		"1.45": MaxAPIVersion,
+		"":     FallbackAPIVersion,
+	} {
+		var mu sync.Mutex
+		var paths []string
+
+		daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			paths = append(paths, r.URL.Path)
+			mu.Unlock()
+
+			if strings.HasSuffix(r.URL.Path, "/_ping") {
+				if len(daemonVersion) > 0 {
+					w.Header().Set("API-Version", daemonVersion)
+				}
+				w.Write([]byte("OK"))
+				return
+			}
+
+			json.NewEncoder(w).Encode(testContainers)
+		}))
+
+		dockerd := DockerD{
+			ControlAddr: "tcp://" + daemon.Listener.Addr().String(),
+			Retry:       RetryPolicy{MaxAttempts: 1},
+		}
+
+		_, err := dockerd.ExecuteListQuery(context.Background(), []string{ObserveLabel})
+		assert.NilError(t, err)
+
+		version, err := dockerd.APIVersion(context.Background())
+		assert.NilError(t, err)
+		assert.Equal(t, version, want)
+
+		// the ping happens once, and the list uses what it found
+		mu.Lock()
+		assert.EqualStringSlice(t, paths, []string{"/_ping", "/v" + want + "/containers/json"})
+		mu.Unlock()
+
+		dockerd.Close()
+		daemon.Close()
+	}
+}
+
+func TestDockerDTargetVersion(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+
+		json.NewEncoder(w).Encode(testContainers)
+	}))
+	defer daemon.Close()
+
+	dockerd := DockerD{
+		ControlAddr:   "tcp://" + daemon.Listener.Addr().String(),
+		TargetVersion: "1.25",
+		Retry:         RetryPolicy{MaxAttempts: 1},
+	}
+	defer dockerd.Close()
+
+	version, err := dockerd.APIVersion(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, version, "1.25")
+
+	_, err = dockerd.ExecuteListQuery(context.Background(), []string{ObserveLabel})
+	assert.NilError(t, err)
+
+	// an explicit version is used as-is, without asking the daemon
+	mu.Lock()
+	defer mu.Unlock()
+	assert.EqualStringSlice(t, paths, []string{"/v1.25/containers/json"})
+}
+
+func TestMonitorHealthFilter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckHealthLabel,
+		})).
+		Times(1).
+		Return(filterContainers(map[string]string{
+			"mon.observe":       "1",
+			"mon.checks.health": "1",
+		}, testContainers), nil)
+	m.
+		EXPECT().
+		ExecuteHealthQuery(gomock.Any(), gomock.Eq([]string{
+			ObserveLabel,
+			CheckHealthLabel,
+		}), gomock.Eq(types.Unhealthy)).
+		Times(1).
+		Return([]types.Container{testContainers[4], testContainers[5]}, nil)
+	// the healthy containers aren't inspected at all
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[4])).
+		Times(1).
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[5])).
+		Times(1).
+		Return(testData[5], nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(DefaultRestartTimeoutMs), gomock.Eq(testContainers[4])).
+		Times(1).
+		Return(nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(nonstandardTimeoutValue), gomock.Eq(testContainers[5])).
+		Times(1).
+		Return(nil)
+
+	monitor := Monitor{
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  &versionedDockerAPI{MockDockerAPI: m, version: "1.37"},
+	}
+
+	results, err := monitor.handleContainerHealth(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(results), 4)
+}
+
+func TestMonitorHealthFilterOldDaemon(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	m.
+		EXPECT().
+		ExecuteListQuery(gomock.Any(), gomock.Any()).
+		Times(1).
+		Return([]types.Container{testContainers[6]}, nil)
+	// too old to filter by health, so the container is inspected instead
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Eq(testContainers[6])).
+		Times(1).
+		Return(testData[6], nil)
+
+	monitor := Monitor{
+		Selector: &Selector{Include: []string{testContainerNamePrefix + "*"}},
+		Dockerd:  &versionedDockerAPI{MockDockerAPI: m, version: "1.23"},
+	}
+
+	_, err := monitor.handleContainerHealth(context.Background())
+	assert.NilError(t, err)
+}
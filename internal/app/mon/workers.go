@@ -0,0 +1,145 @@
+package mon
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+)
+
+// DefaultConcurrency is the default number of containers checked at once
+const DefaultConcurrency int = 4
+
+// checkResult is the outcome of checking a single container
+type checkResult struct {
+	id string
+	// checked is false if the container was filtered out before any work was done
+	checked bool
+	// busy is set if another check of the same container was already in flight
+	busy bool
+	// action is the action taken (or attempted), empty if none was needed
+	action string
+	// planned is set if the action was only planned, in a dry run
+	planned bool
+	err     error
+}
+
+// pollResults aggregates the results of every check in a poll
+type pollResults struct {
+	checked int
+	busy    int
+	failed  int
+	actions map[string]int
+	planned map[string]int
+}
+
+func (r *pollResults) add(res checkResult) {
+	if res.checked {
+		r.checked++
+	}
+	if res.busy {
+		r.busy++
+	}
+	if res.err != nil {
+		r.failed++
+	}
+	if len(res.action) > 0 && res.planned {
+		if r.planned == nil {
+			r.planned = map[string]int{}
+		}
+		r.planned[res.action]++
+	} else if len(res.action) > 0 {
+		if r.actions == nil {
+			r.actions = map[string]int{}
+		}
+		r.actions[res.action]++
+	}
+}
+
+// eventful is true if the poll did (or failed to do) something worth reporting
+func (r *pollResults) eventful() bool {
+	return r.failed > 0 || len(r.actions) > 0 || len(r.planned) > 0
+}
+
+func (r *pollResults) fields() Fields {
+	fields := Fields{
+		"checked": r.checked,
+		"busy":    r.busy,
+		"failed":  r.failed,
+	}
+	for action, count := range r.actions {
+		fields[action] = count
+	}
+	for action, count := range r.planned {
+		fields["planned_"+action] = count
+	}
+
+	return fields
+}
+
+// forEachContainer runs check on every container, at most m.Concurrency at a time, returning the results in order
+func (m *Monitor) forEachContainer(ctx context.Context, conts []types.Container, check func(context.Context, types.Container) checkResult) []checkResult {
+	results := make([]checkResult, len(conts))
+
+	workers := m.Concurrency
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+	if workers > len(conts) {
+		workers = len(conts)
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				results[i] = check(ctx, conts[i])
+				results[i].id = conts[i].ID
+			}
+		}()
+	}
+
+	// once we're cancelled, containers that haven't started are left unchecked
+feed:
+	for i := range conts {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// acquire claims a container, so only one action is ever in flight for it - false if it's already claimed
+func (m *Monitor) acquire(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.inFlight == nil {
+		m.inFlight = map[string]bool{}
+	}
+
+	if m.inFlight[id] {
+		return false
+	}
+
+	m.inFlight[id] = true
+	return true
+}
+
+func (m *Monitor) release(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.inFlight, id)
+}
@@ -0,0 +1,112 @@
+package mon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	mocks "github.com/bengreenier/docker-mon/internal/app/mon/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/golang/mock/gomock"
+)
+
+func TestForEachContainerBounded(t *testing.T) {
+	conts := []types.Container{}
+	for i := 0; i < 10; i++ {
+		conts = append(conts, types.Container{ID: fmt.Sprintf("cont_%d", i)})
+	}
+
+	mu := sync.Mutex{}
+	running := 0
+	maxRunning := 0
+
+	monitor := Monitor{Concurrency: 3}
+	results := monitor.forEachContainer(context.Background(), conts, func(ctx context.Context, cont types.Container) checkResult {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		return checkResult{checked: true, action: cont.ID}
+	})
+
+	assert.Equal(t, maxRunning, 3)
+	assert.Equal(t, len(results), len(conts))
+	for i, res := range results {
+		assert.Equal(t, res.action, conts[i].ID)
+	}
+}
+
+func TestMonitorOneActionPerContainer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockDockerAPI(ctrl)
+
+	// a slow restart holds the container, so the event arriving meanwhile is dropped rather than restarting it again
+	restarting := make(chan bool)
+	m.
+		EXPECT().
+		Inspect(gomock.Any(), gomock.Any()).
+		Times(1).
+		Return(testData[4], nil)
+	m.
+		EXPECT().
+		Restart(gomock.Any(), gomock.Eq(DefaultRestartTimeoutMs), gomock.Any()).
+		Times(1).
+		DoAndReturn(func(ctx context.Context, timeoutMs int64, cont types.Container) error {
+			close(restarting)
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		})
+
+	monitor := Monitor{
+		Dockerd: m,
+	}
+
+	done := make(chan checkResult)
+	go func() {
+		done <- monitor.checkContainerHealth(context.Background(), testContainers[4])
+	}()
+
+	<-restarting
+	monitor.HandleEvent(context.Background(), containerEvent(testData[4], HealthStatusEvent+": "+types.Unhealthy))
+	assert.Equal(t, monitor.checkContainerHealth(context.Background(), testContainers[4]).busy, true)
+
+	res := <-done
+	assert.Equal(t, res.action, RestartAction)
+	assert.NilError(t, res.err)
+	assert.Equal(t, len(monitor.inFlight), 0)
+}
+
+func TestPollResults(t *testing.T) {
+	results := pollResults{}
+	assert.Equal(t, results.eventful(), false)
+
+	results.add(checkResult{})
+	results.add(checkResult{checked: true})
+	results.add(checkResult{checked: true, busy: true})
+	results.add(checkResult{checked: true, action: RestartAction})
+	results.add(checkResult{checked: true, action: RemoveAction, err: fmt.Errorf("test failure")})
+	results.add(checkResult{checked: true, action: RemoveAction})
+
+	assert.Equal(t, results.eventful(), true)
+	assert.DeepEqual(t, results.fields(), Fields{
+		"checked":     5,
+		"busy":        1,
+		"failed":      1,
+		RestartAction: 1,
+		RemoveAction:  2,
+	})
+}